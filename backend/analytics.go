@@ -0,0 +1,189 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// how many queries can wait to be written before new ones are dropped, how
+// many are written at once, and how long one waits at most to be written
+const (
+	ANALYTICS_QUEUE_SIZE     = 4096
+	ANALYTICS_BATCH_SIZE     = 256
+	ANALYTICS_FLUSH_INTERVAL = 2 * time.Second
+)
+
+// queryEvent is a query waiting to be written and the user whose session it belongs to
+type queryEvent struct {
+	UserID string
+	Query  models.Query
+}
+
+// AnalyticsWriter writes queries to the database in batches in the
+// background so requests never wait on writing them. The queue is
+// bounded, when it is full new queries are dropped and counted.
+type AnalyticsWriter struct {
+	db      *gorm.DB
+	queue   chan queryEvent
+	dropped atomic.Int64
+}
+
+// the writer log_event queues queries for, set up in main
+var analytics *AnalyticsWriter
+
+func NewAnalyticsWriter(db *gorm.DB) *AnalyticsWriter {
+	return &AnalyticsWriter{db: db, queue: make(chan queryEvent, ANALYTICS_QUEUE_SIZE)}
+}
+
+// Enqueue queues event to be written without blocking, reporting false if
+// the queue was full and it was dropped
+func (w *AnalyticsWriter) Enqueue(event queryEvent) bool {
+	select {
+	case w.queue <- event:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped is how many queries have been dropped because the queue was full
+func (w *AnalyticsWriter) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Run writes queued queries every ANALYTICS_FLUSH_INTERVAL, or as soon as a
+// full batch is waiting, until ctx is cancelled. It then writes everything
+// still queued and returns, so cancel it only once nothing is enqueuing.
+func (w *AnalyticsWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(ANALYTICS_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	batch := make([]queryEvent, 0, ANALYTICS_BATCH_SIZE)
+	var reported int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-w.queue:
+					batch = append(batch, event)
+					if len(batch) == ANALYTICS_BATCH_SIZE {
+						batch = w.flush(batch)
+					}
+				default:
+					w.flush(batch)
+					log.Printf("Analytics writer stopped, %d queries dropped in all\n", w.Dropped())
+					return
+				}
+			}
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) == ANALYTICS_BATCH_SIZE {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+
+			if dropped := w.Dropped(); dropped != reported {
+				log.Printf("Analytics queue full, %d queries dropped so far\n", dropped)
+				reported = dropped
+			}
+		}
+	}
+}
+
+// writes batch and returns it emptied for reuse. If the batch can't be
+// written in one transaction, each query is written on its own so one bad
+// row only loses itself.
+func (w *AnalyticsWriter) flush(batch []queryEvent) []queryEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := w.write(batch)
+	if err == nil {
+		return batch[:0]
+	}
+	log.Printf("Error writing %d analytics queries, writing them one at a time: %v\n", len(batch), err)
+
+	var failed int
+	for _, event := range batch {
+		if err := w.write([]queryEvent{event}); err != nil {
+			log.Printf("Error writing an analytics query of user %s: %v\n", event.UserID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("Dropped %d of %d analytics queries that couldn't be written\n", failed, len(batch))
+	}
+
+	return batch[:0]
+}
+
+// writes batch in one transaction. The users and sessions the queries belong
+// to are created first if they don't exist yet, and each session's last seen
+// time and query count are updated.
+func (w *AnalyticsWriter) write(batch []queryEvent) error {
+	userRows := make(map[string]models.User)
+	sessionRows := make(map[string]models.Session)
+	queries := make([]models.Query, 0, len(batch))
+	for _, event := range batch {
+		if _, exists := userRows[event.UserID]; !exists {
+			userRows[event.UserID] = models.User{UserID: event.UserID, Created: event.Query.QueriedTime}
+		}
+
+		session, exists := sessionRows[event.Query.SessionID]
+		if !exists {
+			session = models.Session{
+				SessionID: event.Query.SessionID,
+				UserID:    event.UserID,
+				Created:   event.Query.QueriedTime,
+			}
+		}
+
+		if event.Query.QueriedTime.After(session.LastSeen) {
+			session.LastSeen = event.Query.QueriedTime
+		}
+		session.QueryCount++
+		sessionRows[event.Query.SessionID] = session
+
+		queries = append(queries, event.Query)
+	}
+
+	return w.db.Transaction(func(tx *gorm.DB) error {
+		// existing users are left as they are
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(mapValues(userRows)).Error; err != nil {
+			return err
+		}
+
+		// existing sessions are extended and their queries counted
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "session_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "last_seen"}, Value: gorm.Expr("GREATEST(sessions.last_seen, excluded.last_seen)")},
+				{Column: clause.Column{Name: "query_count"}, Value: gorm.Expr("sessions.query_count + excluded.query_count")},
+			},
+		}).Create(mapValues(sessionRows)).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&queries).Error
+	})
+}
+
+func mapValues[K comparable, V any](m map[K]V) []V {
+	values := make([]V, 0, len(m))
+	for _, value := range m {
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// returned by ScheduleArchive when no snapshot matches
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// ScheduleArchive keeps every version of every schedule ever fetched, unlike
+// ScheduleCache which only keeps the latest version of the dates in the memo
+// window
+type ScheduleArchive interface {
+	// appends snapshot unless its ContentHash matches the latest snapshot of
+	// the same date
+	Append(snapshot models.ScheduleSnapshot) error
+	// the latest snapshot of date fetched at or before at
+	AsOf(date time.Time, at time.Time) (models.ScheduleSnapshot, error)
+	// every snapshot of date, oldest first
+	List(date time.Time) ([]models.SnapshotSummary, error)
+	Get(id int) (models.ScheduleSnapshot, error)
+
+	AppendChange(change models.ScheduleChange) error
+	// every change to date detected after since, oldest first
+	ChangesSince(date time.Time, since time.Time) ([]models.ScheduleChange, error)
+}
+
+// the archive every fetch is appended to, set up by initDB
+var scheduleArchive ScheduleArchive
+
+// appends a snapshot of the schedule for date fetched at fetchedAt, if date is
+// in the memo window and the schedule changed since it was last archived
+func archiveSchedule(schedule models.ScheduleResp, date time.Time, fetchedAt time.Time) {
+	if !memoPolicy.InWindow(date, fetchedAt) {
+		return
+	}
+
+	hash, err := scheduleHash(schedule)
+	if err != nil {
+		log.Printf("Error hashing %s schedule: %v\n", date.Format("2006-01-02"), err)
+		return
+	}
+
+	snapshot := models.ScheduleSnapshot{
+		ScheduleDate: date,
+		FetchedAt:    fetchedAt,
+		Schedule:     schedule,
+		ContentHash:  hash,
+	}
+
+	if err := scheduleArchive.Append(snapshot); err != nil {
+		log.Printf("Error archiving %s schedule: %v\n", date.Format("2006-01-02"), err)
+	}
+}
+
+// the hex sha256 of the schedule's JSON. Maps are marshaled with sorted keys,
+// so the same schedule always hashes the same.
+func scheduleHash(schedule models.ScheduleResp) (string, error) {
+	body, err := json.Marshal(schedule)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Postgres
+
+type postgresScheduleArchive struct {
+	db *gorm.DB
+}
+
+// NewPostgresScheduleArchive stores snapshots in the schedule_snapshots table
+func NewPostgresScheduleArchive(db *gorm.DB) ScheduleArchive {
+	return &postgresScheduleArchive{db: db}
+}
+
+func (p *postgresScheduleArchive) Append(snapshot models.ScheduleSnapshot) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		var latest models.ScheduleSnapshot
+		err := tx.Select("content_hash").
+			Where("schedule_date = ?", snapshot.ScheduleDate).
+			Order("fetched_at DESC").
+			Limit(1).
+			Find(&latest).Error
+		if err != nil {
+			return err
+		}
+
+		if snapshot.ContentHash != "" && latest.ContentHash == snapshot.ContentHash {
+			return nil
+		}
+		return tx.Create(&snapshot).Error
+	})
+}
+
+func (p *postgresScheduleArchive) AsOf(date time.Time, at time.Time) (models.ScheduleSnapshot, error) {
+	var snapshot models.ScheduleSnapshot
+
+	err := p.db.Where("schedule_date = ? AND fetched_at <= ?", date, at).
+		Order("fetched_at DESC").
+		First(&snapshot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.ScheduleSnapshot{}, ErrSnapshotNotFound
+	} else if err != nil {
+		return models.ScheduleSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (p *postgresScheduleArchive) List(date time.Time) ([]models.SnapshotSummary, error) {
+	summaries := []models.SnapshotSummary{}
+
+	err := p.db.Model(&models.ScheduleSnapshot{}).
+		Select("id", "fetched_at").
+		Where("schedule_date = ?", date).
+		Order("fetched_at").
+		Find(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func (p *postgresScheduleArchive) Get(id int) (models.ScheduleSnapshot, error) {
+	var snapshot models.ScheduleSnapshot
+
+	err := p.db.First(&snapshot, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.ScheduleSnapshot{}, ErrSnapshotNotFound
+	} else if err != nil {
+		return models.ScheduleSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (p *postgresScheduleArchive) AppendChange(change models.ScheduleChange) error {
+	return p.db.Create(&change).Error
+}
+
+func (p *postgresScheduleArchive) ChangesSince(date time.Time, since time.Time) ([]models.ScheduleChange, error) {
+	var changes []models.ScheduleChange
+
+	err := p.db.Where("schedule_date = ? AND detected_at > ?", date, since).
+		Order("detected_at").
+		Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// Handlers
+
+// GET /history/schedule?date=yyyy-mm-dd&at=RFC3339 returns the schedule for
+// date as it was last fetched at or before at, which defaults to now. Takes
+// the same gym and facility filters as /schedule.
+func historicalSchedule(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	at := time.Now()
+	if value := c.Query("at"); value != "" {
+		at, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at parameter must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := scheduleArchive.AsOf(date, at)
+	if errors.Is(err, ErrSnapshotNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no schedule was fetched for that date by then"})
+		return
+	} else if err != nil {
+		log.Printf("Error getting the %s snapshot as of %v: %v\n", c.Query("date"), at, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshotResp(snapshot, filter))
+}
+
+// GET /history/snapshots?date=yyyy-mm-dd lists every snapshot of date
+func listSnapshots(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	summaries, err := scheduleArchive.List(date)
+	if err != nil {
+		log.Printf("Error listing the %s snapshots: %v\n", c.Query("date"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"date": c.Query("date"), "snapshots": summaries})
+}
+
+// GET /history/snapshots/:id returns a single snapshot, with the same gym
+// and facility filters as /schedule
+func getSnapshot(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "snapshot id must be a number"})
+		return
+	}
+
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := scheduleArchive.Get(id)
+	if errors.Is(err, ErrSnapshotNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error getting snapshot %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshotResp(snapshot, filter))
+}
+
+func snapshotResp(snapshot models.ScheduleSnapshot, filter ScheduleFilter) models.SnapshotResp {
+	return models.SnapshotResp{
+		Id:        snapshot.Id,
+		Date:      snapshot.ScheduleDate.Format("2006-01-02"),
+		FetchedAt: snapshot.FetchedAt,
+		Schedule:  filter.Apply(snapshot.Schedule),
+	}
+}
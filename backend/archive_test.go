@@ -0,0 +1,39 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"testing"
+	"time"
+)
+
+func TestArchiveSchedule(t *testing.T) {
+	previous := scheduleArchive
+	archive := &memoryScheduleArchive{}
+	scheduleArchive = archive
+	t.Cleanup(func() { scheduleArchive = previous })
+
+	today := scheduleDate(time.Now())
+	basketball := testEvent("Open Rec Basketball", "Court 1", "06:00", "11:00")
+	schedule := testSchedule("nick", models.FacilityEvents{"courts": {basketball}})
+	changed := testSchedule("nick", models.FacilityEvents{"courts": {basketball, testEvent("Club Volleyball", "Court 2", "18:00", "20:00")}})
+
+	now := time.Now()
+	archiveSchedule(schedule, today, now)
+	archiveSchedule(schedule, today, now.Add(time.Minute))
+	if len(archive.snapshots) != 1 {
+		t.Fatalf("archived %d snapshots of an unchanged schedule, want 1", len(archive.snapshots))
+	}
+
+	archiveSchedule(changed, today, now.Add(2*time.Minute))
+	archiveSchedule(schedule, today, now.Add(3*time.Minute))
+	if len(archive.snapshots) != 3 {
+		t.Fatalf("archived %d snapshots after two changes, want 3", len(archive.snapshots))
+	}
+
+	// outside of the memo window
+	archiveSchedule(schedule, today.AddDate(0, 0, memoPolicy.DaysAhead+1), now)
+	archiveSchedule(schedule, today.AddDate(0, 0, -memoPolicy.DaysBehind-1), now)
+	if len(archive.snapshots) != 3 {
+		t.Errorf("archived %d snapshots, want dates outside the memo window skipped", len(archive.snapshots))
+	}
+}
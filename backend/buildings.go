@@ -0,0 +1,130 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// the registry that ships with the backend, used unless BUILDINGS_CONFIG points elsewhere
+//
+//go:embed buildings.json
+var defaultBuildingsConfig []byte
+
+// slugs are used as JSON keys and in URLs, so keep them simple
+var slugPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Building is a UW building whose schedule can be fetched from the RecWell's EMS
+type Building struct {
+	Slug       string `json:"slug"`
+	Title      string `json:"title"`
+	BuildingId int    `json:"building_id"`
+	Encrypt    string `json:"encrypt"`
+
+	Hours BuildingHours `json:"hours"`
+
+	// the rooms of each facility, by facility, so rooms without a booking
+	// that day are known to be free. Rooms that show up in events are known
+	// about either way.
+	Rooms map[string][]string `json:"rooms,omitempty"`
+}
+
+// BuildingRegistry is every building the API serves, in the order they were configured
+type BuildingRegistry struct {
+	Buildings []Building `json:"buildings"`
+}
+
+// the registry loaded at startup
+var buildings BuildingRegistry
+
+// loads the building registry from the JSON file at path, or the embedded
+// default registry if path is empty
+func loadBuildings(path string) (BuildingRegistry, error) {
+	config := defaultBuildingsConfig
+	if path != "" {
+		var err error
+		config, err = os.ReadFile(path)
+		if err != nil {
+			return BuildingRegistry{}, fmt.Errorf("failed to read building registry: %w", err)
+		}
+	}
+
+	var registry BuildingRegistry
+	if err := json.Unmarshal(config, &registry); err != nil {
+		return BuildingRegistry{}, fmt.Errorf("error parsing building registry: %w", err)
+	}
+
+	if err := registry.validate(); err != nil {
+		return BuildingRegistry{}, fmt.Errorf("invalid building registry: %w", err)
+	}
+
+	return registry, nil
+}
+
+func (r BuildingRegistry) validate() error {
+	if len(r.Buildings) == 0 {
+		return errors.New("no buildings configured")
+	}
+
+	seen := make(map[string]bool)
+	for _, building := range r.Buildings {
+		if !slugPattern.MatchString(building.Slug) {
+			return fmt.Errorf("slug %q must match %s", building.Slug, slugPattern)
+		}
+		if seen[building.Slug] {
+			return fmt.Errorf("duplicate slug %q", building.Slug)
+		}
+		seen[building.Slug] = true
+
+		if building.Title == "" || building.BuildingId == 0 || building.Encrypt == "" {
+			return fmt.Errorf("%s must have a title, building_id, and encrypt url", building.Slug)
+		}
+
+		if err := building.Hours.validate(); err != nil {
+			return fmt.Errorf("%s hours: %w", building.Slug, err)
+		}
+
+		for facility, rooms := range building.Rooms {
+			if !slugPattern.MatchString(facility) {
+				return fmt.Errorf("%s rooms: facility %q must match %s", building.Slug, facility, slugPattern)
+			}
+			for _, room := range rooms {
+				if strings.TrimSpace(room) == "" {
+					return fmt.Errorf("%s rooms: %s has an empty room name", building.Slug, facility)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get returns the building with the given slug
+func (r BuildingRegistry) Get(slug string) (Building, bool) {
+	for _, building := range r.Buildings {
+		if building.Slug == slug {
+			return building, true
+		}
+	}
+
+	return Building{}, false
+}
+
+// RoomsOf returns the configured rooms of the building's facility
+func (b Building) RoomsOf(facility string) []string {
+	return b.Rooms[facility]
+}
+
+// Slugs returns the slug of every configured building
+func (r BuildingRegistry) Slugs() []string {
+	slugs := make([]string, 0, len(r.Buildings))
+	for _, building := range r.Buildings {
+		slugs = append(slugs, building.Slug)
+	}
+
+	return slugs
+}
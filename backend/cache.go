@@ -0,0 +1,252 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// returned by ScheduleCache.Get when the date has no memoized schedule
+var ErrScheduleNotCached = errors.New("schedule not cached")
+
+// ScheduleCache stores memoized schedules by date. Staleness is up to the
+// caller, the cache returns whatever it has along with when it was created.
+// Returned schedules may be shared with other callers and must not be modified.
+type ScheduleCache interface {
+	Get(date time.Time) (models.Schedule, error)
+	// every memoized schedule in [start, end], in no particular order
+	GetRange(start time.Time, end time.Time) ([]models.Schedule, error)
+	// inserts or replaces the schedule for schedule.ScheduleDate
+	Set(schedule models.Schedule) error
+	DeleteBefore(date time.Time) error
+}
+
+// the cache behind memoSchedule and getSchedule, chosen by newScheduleCache at startup
+var scheduleCache ScheduleCache
+
+const DEFAULT_SCHEDULE_CACHE_SIZE = 64
+
+// builds the cache selected by SCHEDULE_CACHE: "postgres" (the default),
+// "memory" for an in-process LRU of SCHEDULE_CACHE_SIZE dates, or "tiered"
+// for the LRU in front of postgres
+func newScheduleCache(db *gorm.DB) (ScheduleCache, error) {
+	size := DEFAULT_SCHEDULE_CACHE_SIZE
+	if value := os.Getenv("SCHEDULE_CACHE_SIZE"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("SCHEDULE_CACHE_SIZE must be a positive integer, found %q", value)
+		}
+	}
+
+	switch kind := os.Getenv("SCHEDULE_CACHE"); kind {
+	case "", "postgres":
+		return NewPostgresScheduleCache(db), nil
+	case "memory":
+		return NewLRUScheduleCache(size), nil
+	case "tiered":
+		return NewTieredScheduleCache(NewLRUScheduleCache(size), NewPostgresScheduleCache(db)), nil
+	default:
+		return nil, fmt.Errorf("SCHEDULE_CACHE must be \"postgres\", \"memory\", or \"tiered\", found %q", kind)
+	}
+}
+
+func cacheKey(date time.Time) string {
+	return date.Format("2006-01-02")
+}
+
+// Postgres
+
+type postgresScheduleCache struct {
+	db *gorm.DB
+}
+
+// NewPostgresScheduleCache stores schedules in the schedules table
+func NewPostgresScheduleCache(db *gorm.DB) ScheduleCache {
+	return &postgresScheduleCache{db: db}
+}
+
+func (p *postgresScheduleCache) Get(date time.Time) (models.Schedule, error) {
+	var schedule models.Schedule
+
+	err := p.db.Where("schedule_date = ?", date).First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Schedule{}, ErrScheduleNotCached
+	} else if err != nil {
+		return models.Schedule{}, err
+	}
+
+	return schedule, nil
+}
+
+func (p *postgresScheduleCache) GetRange(start time.Time, end time.Time) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+
+	err := p.db.Where("schedule_date BETWEEN ? AND ?", start, end).Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (p *postgresScheduleCache) Set(schedule models.Schedule) error {
+	// Attempt to insert or update the record
+	return p.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "schedule_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"created", "schedule"}),
+	}).Create(&schedule).Error
+}
+
+func (p *postgresScheduleCache) DeleteBefore(date time.Time) error {
+	return p.db.Where("schedule_date < ?", date).Delete(&models.Schedule{}).Error
+}
+
+// In-process LRU
+
+type lruScheduleCache struct {
+	mu       sync.Mutex
+	capacity int
+	// most recently used at the front, every element holds a models.Schedule
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewLRUScheduleCache keeps the capacity most recently used dates in memory
+func NewLRUScheduleCache(capacity int) ScheduleCache {
+	return &lruScheduleCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (l *lruScheduleCache) Get(date time.Time) (models.Schedule, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, exists := l.entries[cacheKey(date)]
+	if !exists {
+		return models.Schedule{}, ErrScheduleNotCached
+	}
+
+	l.order.MoveToFront(element)
+	return element.Value.(models.Schedule), nil
+}
+
+func (l *lruScheduleCache) GetRange(start time.Time, end time.Time) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if schedule, err := l.Get(day); err == nil {
+			schedules = append(schedules, schedule)
+		}
+	}
+
+	return schedules, nil
+}
+
+func (l *lruScheduleCache) Set(schedule models.Schedule) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := cacheKey(schedule.ScheduleDate)
+	if element, exists := l.entries[key]; exists {
+		element.Value = schedule
+		l.order.MoveToFront(element)
+		return nil
+	}
+
+	l.entries[key] = l.order.PushFront(schedule)
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, cacheKey(oldest.Value.(models.Schedule).ScheduleDate))
+	}
+
+	return nil
+}
+
+func (l *lruScheduleCache) DeleteBefore(date time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := cacheKey(date)
+	for key, element := range l.entries {
+		if key < cutoff {
+			l.order.Remove(element)
+			delete(l.entries, key)
+		}
+	}
+
+	return nil
+}
+
+// Memory in front of a backing cache
+
+type tieredScheduleCache struct {
+	memory  ScheduleCache
+	backing ScheduleCache
+}
+
+// NewTieredScheduleCache serves from memory when it can and falls back to
+// backing, filling memory with what it finds. Writes go to both.
+func NewTieredScheduleCache(memory ScheduleCache, backing ScheduleCache) ScheduleCache {
+	return &tieredScheduleCache{memory: memory, backing: backing}
+}
+
+func (t *tieredScheduleCache) Get(date time.Time) (models.Schedule, error) {
+	if schedule, err := t.memory.Get(date); err == nil {
+		return schedule, nil
+	}
+
+	schedule, err := t.backing.Get(date)
+	if err != nil {
+		return models.Schedule{}, err
+	}
+
+	t.memory.Set(schedule)
+	return schedule, nil
+}
+
+func (t *tieredScheduleCache) GetRange(start time.Time, end time.Time) ([]models.Schedule, error) {
+	// only skip the backing query if memory has every day
+	schedules, err := t.memory.GetRange(start, end)
+	days := int(end.Sub(start).Hours()/24) + 1
+	if err == nil && len(schedules) == days {
+		return schedules, nil
+	}
+
+	schedules, err = t.backing.GetRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		t.memory.Set(schedule)
+	}
+	return schedules, nil
+}
+
+func (t *tieredScheduleCache) Set(schedule models.Schedule) error {
+	if err := t.backing.Set(schedule); err != nil {
+		return err
+	}
+
+	return t.memory.Set(schedule)
+}
+
+func (t *tieredScheduleCache) DeleteBefore(date time.Time) error {
+	if err := t.backing.DeleteBefore(date); err != nil {
+		return err
+	}
+
+	return t.memory.DeleteBefore(date)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// the schedule date n days after 2025-04-01
+func testDate(n int) time.Time {
+	return time.Date(2025, 4, 1+n, 0, 0, 0, 0, time.UTC)
+}
+
+func testMemo(n int) models.Schedule {
+	return models.Schedule{ScheduleDate: testDate(n), Created: testClock("12:00"), Schedule: testSchedule("nick", models.FacilityEvents{})}
+}
+
+// the yyyy-mm-dd of every day cache has in [start, end], in order
+func cachedDates(t *testing.T, cache ScheduleCache, start int, end int) []string {
+	t.Helper()
+
+	schedules, err := cache.GetRange(testDate(start), testDate(end))
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+
+	dates := []string{}
+	for _, schedule := range schedules {
+		dates = append(dates, cacheKey(schedule.ScheduleDate))
+	}
+	slices.Sort(dates)
+	return dates
+}
+
+func TestLRUScheduleCacheEviction(t *testing.T) {
+	cache := NewLRUScheduleCache(2)
+	cache.Set(testMemo(0))
+	cache.Set(testMemo(1))
+	cache.Set(testMemo(2))
+
+	if _, err := cache.Get(testDate(0)); !errors.Is(err, ErrScheduleNotCached) {
+		t.Errorf("Get of the least recently used date = %v, want ErrScheduleNotCached", err)
+	}
+	if got, want := cachedDates(t, cache, 0, 2), []string{"2025-04-02", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("cached = %v, want %v", got, want)
+	}
+
+	// replacing a date doesn't grow the cache
+	cache.Set(testMemo(2))
+	if got, want := cachedDates(t, cache, 0, 2), []string{"2025-04-02", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("cached after replacing = %v, want %v", got, want)
+	}
+}
+
+func TestLRUScheduleCacheGetPromotes(t *testing.T) {
+	cache := NewLRUScheduleCache(2)
+	cache.Set(testMemo(0))
+	cache.Set(testMemo(1))
+
+	if _, err := cache.Get(testDate(0)); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	cache.Set(testMemo(2))
+
+	if got, want := cachedDates(t, cache, 0, 2), []string{"2025-04-01", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("cached = %v, want %v, the date just read kept", got, want)
+	}
+}
+
+func TestLRUScheduleCacheDeleteBefore(t *testing.T) {
+	cache := NewLRUScheduleCache(8)
+	for n := range 4 {
+		cache.Set(testMemo(n))
+	}
+
+	if err := cache.DeleteBefore(testDate(2)); err != nil {
+		t.Fatalf("DeleteBefore: %v", err)
+	}
+	if got, want := cachedDates(t, cache, 0, 3), []string{"2025-04-03", "2025-04-04"}; !slices.Equal(got, want) {
+		t.Errorf("cached = %v, want %v", got, want)
+	}
+
+	// the deleted dates no longer count towards the capacity
+	for n := 4; n < 10; n++ {
+		cache.Set(testMemo(n))
+	}
+	if got := cachedDates(t, cache, 0, 9); len(got) != 8 {
+		t.Errorf("cached %d dates, want 8", len(got))
+	}
+}
+
+func TestTieredScheduleCacheGet(t *testing.T) {
+	memory, backing := NewLRUScheduleCache(8), NewLRUScheduleCache(8)
+	cache := NewTieredScheduleCache(memory, backing)
+	backing.Set(testMemo(0))
+
+	if _, err := cache.Get(testDate(0)); err != nil {
+		t.Fatalf("Get of a date only in backing: %v", err)
+	}
+	if _, err := memory.Get(testDate(0)); err != nil {
+		t.Errorf("memory wasn't filled from backing: %v", err)
+	}
+
+	if _, err := cache.Get(testDate(1)); !errors.Is(err, ErrScheduleNotCached) {
+		t.Errorf("Get of a date in neither = %v, want ErrScheduleNotCached", err)
+	}
+
+	// writes go to both
+	cache.Set(testMemo(2))
+	if _, err := backing.Get(testDate(2)); err != nil {
+		t.Errorf("Set didn't write to backing: %v", err)
+	}
+	if _, err := memory.Get(testDate(2)); err != nil {
+		t.Errorf("Set didn't write to memory: %v", err)
+	}
+}
+
+func TestTieredScheduleCacheGetRange(t *testing.T) {
+	memory, backing := NewLRUScheduleCache(8), NewLRUScheduleCache(8)
+	cache := NewTieredScheduleCache(memory, backing)
+	for n := range 3 {
+		backing.Set(testMemo(n))
+	}
+	memory.Set(testMemo(0))
+	memory.Set(testMemo(1))
+
+	// memory is missing a day, so the range comes from backing
+	if got, want := cachedDates(t, cache, 0, 2), []string{"2025-04-01", "2025-04-02", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("GetRange = %v, want %v", got, want)
+	}
+	if got, want := cachedDates(t, memory, 0, 2), []string{"2025-04-01", "2025-04-02", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("memory after GetRange = %v, want %v", got, want)
+	}
+
+	// with every day in memory, backing isn't needed
+	backing.DeleteBefore(testDate(3))
+	if got, want := cachedDates(t, cache, 0, 2), []string{"2025-04-01", "2025-04-02", "2025-04-03"}; !slices.Equal(got, want) {
+		t.Errorf("GetRange with every day in memory = %v, want %v", got, want)
+	}
+}
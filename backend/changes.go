@@ -0,0 +1,208 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// events are matched across versions of a schedule by where and when they start
+type eventKey struct {
+	Gym   string
+	Room  string
+	Start int64
+}
+
+type locatedEvent struct {
+	Facility string
+	Event    models.Event
+}
+
+// diffs the schedule fetched at fetchedAt against the previous memo of the
+// same date, and archives the change and notifies webhooks of it if
+// anything changed
+func recordChanges(previous models.Schedule, schedule models.ScheduleResp, date time.Time, fetchedAt time.Time) (models.ScheduleChange, bool) {
+	diff := diffSchedules(previous.Schedule, schedule)
+	if diff.Empty() {
+		return models.ScheduleChange{}, false
+	}
+
+	change := models.ScheduleChange{
+		ScheduleDate:      date,
+		DetectedAt:        fetchedAt,
+		PreviousFetchedAt: previous.Created,
+		Diff:              diff,
+	}
+
+	log.Printf("%s schedule changed: %d added, %d removed, %d changed\n",
+		date.Format("2006-01-02"), len(diff.Added), len(diff.Removed), len(diff.Changed))
+
+	if err := scheduleArchive.AppendChange(change); err != nil {
+		log.Printf("Error archiving %s schedule change: %v\n", date.Format("2006-01-02"), err)
+	}
+
+	if webhooks != nil {
+		webhooks.Notify(change)
+	}
+
+	return change, true
+}
+
+// returns the events added, removed, and changed going from before to after
+func diffSchedules(before models.ScheduleResp, after models.ScheduleResp) models.ScheduleDiff {
+	beforeEvents := indexEvents(before)
+	afterEvents := indexEvents(after)
+
+	keys := make(map[eventKey]bool, len(beforeEvents)+len(afterEvents))
+	for key := range beforeEvents {
+		keys[key] = true
+	}
+	for key := range afterEvents {
+		keys[key] = true
+	}
+
+	diff := models.ScheduleDiff{
+		Added:   []models.EventChange{},
+		Removed: []models.EventChange{},
+		Changed: []models.EventChange{},
+	}
+
+	for key := range keys {
+		// several events can start in the same room at once, pair off the
+		// identical ones first and treat the rest as changed in order
+		olds := slices.Clone(beforeEvents[key])
+		news := slices.Clone(afterEvents[key])
+		olds = slices.DeleteFunc(olds, func(old locatedEvent) bool {
+			i := slices.IndexFunc(news, func(candidate locatedEvent) bool { return sameEvent(old, candidate) })
+			if i < 0 {
+				return false
+			}
+			news = slices.Delete(news, i, i+1)
+			return true
+		})
+
+		for i := 0; i < len(olds) || i < len(news); i++ {
+			switch {
+			case i >= len(news):
+				diff.Removed = append(diff.Removed, eventChange(key, &olds[i], nil))
+			case i >= len(olds):
+				diff.Added = append(diff.Added, eventChange(key, nil, &news[i]))
+			default:
+				diff.Changed = append(diff.Changed, eventChange(key, &olds[i], &news[i]))
+			}
+		}
+	}
+
+	sortEventChanges(diff.Added)
+	sortEventChanges(diff.Removed)
+	sortEventChanges(diff.Changed)
+	return diff
+}
+
+// events read back from the cache are in a different *time.Location than
+// freshly fetched ones, so compare instants rather than using ==
+func sameEvent(a locatedEvent, b locatedEvent) bool {
+	return a.Facility == b.Facility &&
+		a.Event.Name == b.Event.Name &&
+		a.Event.Location == b.Event.Location &&
+		a.Event.Start.Equal(b.Event.Start) &&
+		a.Event.End.Equal(b.Event.End)
+}
+
+func indexEvents(schedule models.ScheduleResp) map[eventKey][]locatedEvent {
+	index := make(map[eventKey][]locatedEvent)
+	for gym, building := range schedule {
+		for facility, events := range building.Facilities {
+			for _, event := range events {
+				key := eventKey{Gym: gym, Room: event.Location, Start: event.Start.Unix()}
+				index[key] = append(index[key], locatedEvent{Facility: facility, Event: event})
+			}
+		}
+	}
+	return index
+}
+
+func eventChange(key eventKey, before *locatedEvent, after *locatedEvent) models.EventChange {
+	change := models.EventChange{Gym: key.Gym, Room: key.Room}
+
+	if before != nil {
+		change.Facility = before.Facility
+		change.Start = before.Event.Start
+		change.Before = &before.Event
+	}
+	if after != nil {
+		// an event reclassified into another facility is reported under its new one
+		change.Facility = after.Facility
+		change.Start = after.Event.Start
+		change.After = &after.Event
+	}
+
+	return change
+}
+
+func sortEventChanges(changes []models.EventChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if a.Gym != b.Gym {
+			return a.Gym < b.Gym
+		}
+		return compareRooms(a.Room, b.Room) < 0
+	})
+}
+
+// GET /schedule/changes?date=yyyy-mm-dd&since=RFC3339 returns every change to
+// date's schedule detected after since, oldest first. since defaults to
+// returning every change. Takes the same gym and facility filters as /schedule.
+func scheduleChanges(c *gin.Context) {
+	date, err := time.Parse("2006-01-02", c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since parameter must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changes, err := scheduleArchive.ChangesSince(date, since)
+	if err != nil {
+		log.Printf("Error getting the %s changes since %v: %v\n", c.Query("date"), since, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	resp := []models.ChangeResp{}
+	for _, change := range changes {
+		diff := filter.ApplyDiff(change.Diff)
+		if diff.Empty() {
+			continue
+		}
+
+		resp = append(resp, models.ChangeResp{
+			DetectedAt:        change.DetectedAt,
+			PreviousFetchedAt: change.PreviousFetchedAt,
+			ScheduleDiff:      diff,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"date": c.Query("date"), "changes": resp})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// the time "15:04" on 2025-04-01 in Madison
+func testClock(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2025-04-01 "+clock, CENTRAL_TIME)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// an event in Madison on 2025-04-01 from start to end, both "15:04"
+func testEvent(name string, room string, start string, end string) models.Event {
+	event := models.Event{Name: name, Location: room, Start: testClock(start), End: testClock(end)}
+	event.DurationMinutes = int(event.Duration().Minutes())
+	return event
+}
+
+// a schedule with a single building of the given facilities
+func testSchedule(gym string, facilities models.FacilityEvents) models.ScheduleResp {
+	return models.ScheduleResp{gym: {Title: gym, Facilities: facilities}}
+}
+
+// describes each change as "<gym> <facility> <room> <start>", with the
+// before and after names when they differ, to compare diffs by
+func describeChanges(changes []models.EventChange) []string {
+	described := make([]string, 0, len(changes))
+	for _, change := range changes {
+		description := fmt.Sprintf("%s %s %s %s", change.Gym, change.Facility, change.Room, change.Start.In(CENTRAL_TIME).Format("15:04"))
+		if change.Before != nil && change.After != nil {
+			description += fmt.Sprintf(" %s-%s -> %s-%s",
+				change.Before.Start.In(CENTRAL_TIME).Format("15:04"), change.Before.End.In(CENTRAL_TIME).Format("15:04"),
+				change.After.Start.In(CENTRAL_TIME).Format("15:04"), change.After.End.In(CENTRAL_TIME).Format("15:04"))
+			if change.Before.Name != change.After.Name {
+				description += fmt.Sprintf(" %q -> %q", change.Before.Name, change.After.Name)
+			}
+		}
+		described = append(described, description)
+	}
+	return described
+}
+
+// returns schedule with every event's times moved to UTC, like a schedule
+// that has been through JSON and the database
+func inUTC(schedule models.ScheduleResp) models.ScheduleResp {
+	moved := make(models.ScheduleResp, len(schedule))
+	for gym, building := range schedule {
+		facilities := make(models.FacilityEvents, len(building.Facilities))
+		for facility, events := range building.Facilities {
+			for _, event := range events {
+				event.Start = event.Start.UTC()
+				event.End = event.End.UTC()
+				facilities[facility] = append(facilities[facility], event)
+			}
+		}
+		building.Facilities = facilities
+		moved[gym] = building
+	}
+	return moved
+}
+
+func TestDiffSchedules(t *testing.T) {
+	basketball := testEvent("Open Rec Basketball", "Court 1", "06:00", "11:00")
+	volleyball := testEvent("Intramural Volleyball", "Court 2", "18:00", "22:00")
+	base := testSchedule("nick", models.FacilityEvents{"courts": {basketball, volleyball}})
+
+	tests := []struct {
+		name        string
+		before      models.ScheduleResp
+		after       models.ScheduleResp
+		wantAdded   []string
+		wantRemoved []string
+		wantChanged []string
+	}{
+		{
+			name:   "unchanged",
+			before: base,
+			after:  testSchedule("nick", models.FacilityEvents{"courts": {volleyball, basketball}}),
+		},
+		{
+			name:   "unchanged but read back in UTC",
+			before: inUTC(base),
+			after:  base,
+		},
+		{
+			name:      "no previous schedule",
+			before:    nil,
+			after:     base,
+			wantAdded: []string{"nick courts Court 1 06:00", "nick courts Court 2 18:00"},
+		},
+		{
+			name:      "added",
+			before:    base,
+			after:     testSchedule("nick", models.FacilityEvents{"courts": {basketball, volleyball, testEvent("Open Rec Basketball", "Court 3", "12:00", "14:00")}}),
+			wantAdded: []string{"nick courts Court 3 12:00"},
+		},
+		{
+			name:        "removed",
+			before:      base,
+			after:       testSchedule("nick", models.FacilityEvents{"courts": {volleyball}}),
+			wantRemoved: []string{"nick courts Court 1 06:00"},
+		},
+		{
+			name:        "extended",
+			before:      base,
+			after:       testSchedule("nick", models.FacilityEvents{"courts": {testEvent("Open Rec Basketball", "Court 1", "06:00", "12:00"), volleyball}}),
+			wantChanged: []string{"nick courts Court 1 06:00 06:00-11:00 -> 06:00-12:00"},
+		},
+		{
+			name:        "renamed",
+			before:      base,
+			after:       testSchedule("nick", models.FacilityEvents{"courts": {testEvent("Club Basketball", "Court 1", "06:00", "11:00"), volleyball}}),
+			wantChanged: []string{`nick courts Court 1 06:00 06:00-11:00 -> 06:00-11:00 "Open Rec Basketball" -> "Club Basketball"`},
+		},
+		{
+			name:        "moved to a new start",
+			before:      base,
+			after:       testSchedule("nick", models.FacilityEvents{"courts": {testEvent("Open Rec Basketball", "Court 1", "07:00", "11:00"), volleyball}}),
+			wantAdded:   []string{"nick courts Court 1 07:00"},
+			wantRemoved: []string{"nick courts Court 1 06:00"},
+		},
+		{
+			name:   "reclassified",
+			before: base,
+			after: testSchedule("nick", models.FacilityEvents{
+				"courts":      {volleyball},
+				UNCATEGORIZED: {basketball},
+			}),
+			wantChanged: []string{"nick uncategorized Court 1 06:00 06:00-11:00 -> 06:00-11:00"},
+		},
+		{
+			name:        "one of two identical events removed",
+			before:      testSchedule("nick", models.FacilityEvents{"courts": {basketball, basketball}}),
+			after:       testSchedule("nick", models.FacilityEvents{"courts": {basketball}}),
+			wantRemoved: []string{"nick courts Court 1 06:00"},
+		},
+		{
+			name:   "same room in another building",
+			before: base,
+			after: models.ScheduleResp{
+				"nick":  base["nick"],
+				"bakke": {Title: "bakke", Facilities: models.FacilityEvents{"courts": {basketball}}},
+			},
+			wantAdded: []string{"bakke courts Court 1 06:00"},
+		},
+		{
+			name:      "sorted by start",
+			before:    testSchedule("nick", nil),
+			after:     testSchedule("nick", models.FacilityEvents{"courts": {volleyball, testEvent("Open Rec Basketball", "Court 10", "06:00", "08:00"), basketball}}),
+			wantAdded: []string{"nick courts Court 1 06:00", "nick courts Court 10 06:00", "nick courts Court 2 18:00"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := diffSchedules(test.before, test.after)
+
+			check := func(kind string, got []models.EventChange, want []string) {
+				if !slices.Equal(describeChanges(got), append([]string{}, want...)) {
+					t.Errorf("%s = %q, want %q", kind, describeChanges(got), want)
+				}
+			}
+			check("added", diff.Added, test.wantAdded)
+			check("removed", diff.Removed, test.wantRemoved)
+			check("changed", diff.Changed, test.wantChanged)
+
+			wantEmpty := len(test.wantAdded)+len(test.wantRemoved)+len(test.wantChanged) == 0
+			if diff.Empty() != wantEmpty {
+				t.Errorf("Empty() = %v, want %v", diff.Empty(), wantEmpty)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// the rules that ship with the backend, used unless FACILITY_RULES_CONFIG points elsewhere
+//
+//go:embed facilities.json
+var defaultFacilityRulesConfig []byte
+
+// the facility of any room that no rule matches
+const UNCATEGORIZED = "uncategorized"
+
+// FacilityRule maps a room to a facility when any of its keywords is a
+// substring of the room name or its pattern matches it, case insensitively
+type FacilityRule struct {
+	Facility string   `json:"facility"`
+	Keywords []string `json:"keywords,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+}
+
+// FacilityRulesConfig is the on disk form of the rules. Rules listed under a
+// building slug are checked, in order, before the shared rules.
+type FacilityRulesConfig struct {
+	Rules     []FacilityRule            `json:"rules"`
+	Buildings map[string][]FacilityRule `json:"buildings"`
+}
+
+type compiledRule struct {
+	facility string
+	keywords []string
+	pattern  *regexp.Regexp
+}
+
+// FacilityClassifier sorts EMS rooms into facilities using ordered rules
+type FacilityClassifier struct {
+	facilities    []string
+	rules         []compiledRule
+	buildingRules map[string][]compiledRule
+}
+
+// the classifier in use, swapped out atomically by reloadFacilityRules
+var classifier atomic.Pointer[FacilityClassifier]
+
+// loads the facility rules from the JSON file at path, or the embedded
+// default rules if path is empty
+func loadFacilityRules(path string) (*FacilityClassifier, error) {
+	config := defaultFacilityRulesConfig
+	if path != "" {
+		var err error
+		config, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read facility rules: %w", err)
+		}
+	}
+
+	var rulesConfig FacilityRulesConfig
+	if err := json.Unmarshal(config, &rulesConfig); err != nil {
+		return nil, fmt.Errorf("error parsing facility rules: %w", err)
+	}
+
+	return NewFacilityClassifier(rulesConfig)
+}
+
+// reloads the facility rules from FACILITY_RULES_CONFIG, keeping the current
+// rules if the new ones are invalid
+func reloadFacilityRules() error {
+	newClassifier, err := loadFacilityRules(os.Getenv("FACILITY_RULES_CONFIG"))
+	if err != nil {
+		return err
+	}
+
+	classifier.Store(newClassifier)
+	log.Printf("Loaded facility rules for facilities %v\n", newClassifier.Facilities())
+	return nil
+}
+
+// NewFacilityClassifier compiles the rules in config
+func NewFacilityClassifier(config FacilityRulesConfig) (*FacilityClassifier, error) {
+	c := &FacilityClassifier{
+		buildingRules: make(map[string][]compiledRule),
+	}
+	seen := make(map[string]bool)
+
+	compile := func(rules []FacilityRule) ([]compiledRule, error) {
+		compiled := make([]compiledRule, 0, len(rules))
+		for i, rule := range rules {
+			if !slugPattern.MatchString(rule.Facility) || rule.Facility == UNCATEGORIZED {
+				return nil, fmt.Errorf("rule %d: invalid facility %q", i, rule.Facility)
+			}
+			if len(rule.Keywords) == 0 && rule.Pattern == "" {
+				return nil, fmt.Errorf("rule %d: %s needs keywords or a pattern", i, rule.Facility)
+			}
+
+			matcher := compiledRule{facility: rule.Facility}
+			for _, keyword := range rule.Keywords {
+				matcher.keywords = append(matcher.keywords, normalizeRoom(keyword))
+			}
+			if rule.Pattern != "" {
+				pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: invalid pattern: %w", i, err)
+				}
+				matcher.pattern = pattern
+			}
+
+			if !seen[rule.Facility] {
+				seen[rule.Facility] = true
+				c.facilities = append(c.facilities, rule.Facility)
+			}
+			compiled = append(compiled, matcher)
+		}
+
+		return compiled, nil
+	}
+
+	var err error
+	if c.rules, err = compile(config.Rules); err != nil {
+		return nil, err
+	}
+
+	// sorted so facilities only a building's rules produce come out in a stable order
+	for _, slug := range slices.Sorted(maps.Keys(config.Buildings)) {
+		if c.buildingRules[slug], err = compile(config.Buildings[slug]); err != nil {
+			return nil, fmt.Errorf("%s: %w", slug, err)
+		}
+	}
+
+	if len(c.facilities) == 0 {
+		return nil, errors.New("no facility rules configured")
+	}
+
+	return c, nil
+}
+
+// Classify returns the facility of room in the building with the given slug,
+// or UNCATEGORIZED if no rule matches it
+func (c *FacilityClassifier) Classify(building string, room string) string {
+	room = normalizeRoom(room)
+
+	for _, rules := range [][]compiledRule{c.buildingRules[building], c.rules} {
+		for _, rule := range rules {
+			if rule.matches(room) {
+				return rule.facility
+			}
+		}
+	}
+
+	return UNCATEGORIZED
+}
+
+// Facilities returns every facility the rules can produce, in the order they
+// first appear, followed by UNCATEGORIZED
+func (c *FacilityClassifier) Facilities() []string {
+	return append(append([]string(nil), c.facilities...), UNCATEGORIZED)
+}
+
+func (r compiledRule) matches(room string) bool {
+	for _, keyword := range r.keywords {
+		if strings.Contains(room, keyword) {
+			return true
+		}
+	}
+
+	return r.pattern != nil && r.pattern.MatchString(room)
+}
+
+func normalizeRoom(room string) string {
+	return strings.ToLower(strings.TrimSpace(room))
+}
+
+// reloads the facility rules every time the process receives SIGHUP
+func reloadFacilityRulesOnSIGHUP() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		if err := reloadFacilityRules(); err != nil {
+			log.Printf("Error reloading facility rules, keeping the old rules: %v\n", err)
+		}
+	}
+}
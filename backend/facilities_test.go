@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClassifyDefaultRules(t *testing.T) {
+	classifier, err := loadFacilityRules("")
+	if err != nil {
+		t.Fatalf("loading the default rules: %v", err)
+	}
+
+	// room names as EMS sends them, see recwelltest/testdata
+	tests := []struct {
+		building string
+		room     string
+		want     string
+	}{
+		{"nick", "Court 1", "courts"},
+		{"nick", "Court 2", "courts"},
+		{"bakke", "Court 4", "courts"},
+		{"nick", "Pool - Lane 1", "pool"},
+		{"bakke", "Pool", "pool"},
+		{"nick", "Mount Mendota Climbing Wall", "mount_mendota"},
+		{"bakke", "Ice Rink", "ice_rink"},
+		{"bakke", "Esports Room", "esports"},
+		// case and surrounding whitespace don't matter
+		{"nick", "  COURT 3 ", "courts"},
+		{"bakke", "ESPORTS ROOM", "esports"},
+		// rooms no rule knows about are kept, not dropped
+		{"nick", "Multipurpose Room 2", UNCATEGORIZED},
+		{"bakke", "", UNCATEGORIZED},
+	}
+
+	for _, test := range tests {
+		if got := classifier.Classify(test.building, test.room); got != test.want {
+			t.Errorf("Classify(%q, %q) = %q, want %q", test.building, test.room, got, test.want)
+		}
+	}
+}
+
+func TestClassifyBuildingRules(t *testing.T) {
+	classifier, err := NewFacilityClassifier(FacilityRulesConfig{
+		Rules: []FacilityRule{
+			{Facility: "courts", Keywords: []string{"court"}},
+			{Facility: "pool", Pattern: `^pool\b`},
+		},
+		Buildings: map[string][]FacilityRule{
+			// the Bakke's multipurpose courts are for futsal, not basketball
+			"bakke": {{Facility: "futsal", Keywords: []string{"mac court"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("compiling rules: %v", err)
+	}
+
+	tests := []struct {
+		building string
+		room     string
+		want     string
+	}{
+		{"bakke", "MAC Court", "futsal"},
+		{"nick", "MAC Court", "courts"},
+		{"bakke", "Court 3", "courts"},
+		{"nick", "Pool - Lane 4", "pool"},
+		{"nick", "Whirlpool", UNCATEGORIZED},
+	}
+
+	for _, test := range tests {
+		if got := classifier.Classify(test.building, test.room); got != test.want {
+			t.Errorf("Classify(%q, %q) = %q, want %q", test.building, test.room, got, test.want)
+		}
+	}
+
+	want := []string{"courts", "pool", "futsal", UNCATEGORIZED}
+	if got := classifier.Facilities(); !slices.Equal(got, want) {
+		t.Errorf("Facilities() = %v, want %v", got, want)
+	}
+}
+
+func TestNewFacilityClassifierInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FacilityRulesConfig
+	}{
+		{"no rules", FacilityRulesConfig{}},
+		{"no keywords or pattern", FacilityRulesConfig{Rules: []FacilityRule{{Facility: "courts"}}}},
+		{"invalid facility", FacilityRulesConfig{Rules: []FacilityRule{{Facility: "Courts!", Keywords: []string{"court"}}}}},
+		{"uncategorized facility", FacilityRulesConfig{Rules: []FacilityRule{{Facility: UNCATEGORIZED, Keywords: []string{"room"}}}}},
+		{"invalid pattern", FacilityRulesConfig{Rules: []FacilityRule{{Facility: "courts", Pattern: "court ("}}}},
+		{"invalid building rule", FacilityRulesConfig{
+			Rules:     []FacilityRule{{Facility: "courts", Keywords: []string{"court"}}},
+			Buildings: map[string][]FacilityRule{"nick": {{Facility: "pool"}}},
+		}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewFacilityClassifier(test.config); err == nil {
+			t.Errorf("%s: NewFacilityClassifier succeeded, want an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduleFilter selects which gyms and facilities a schedule response
+// includes. An empty list selects all of them.
+type ScheduleFilter struct {
+	Gyms       []string
+	Facilities []string
+}
+
+// parses the gym and facility query parameters, each a comma separated list
+// that may also be repeated (?gym=nick,bakke or ?gym=nick&gym=bakke), and
+// validates them against the configured buildings and facility rules
+func parseScheduleFilter(c *gin.Context) (ScheduleFilter, error) {
+	var filter ScheduleFilter
+	var err error
+
+	filter.Gyms, err = parseListParam(c, "gym", buildings.Slugs())
+	if err != nil {
+		return ScheduleFilter{}, err
+	}
+
+	filter.Facilities, err = parseListParam(c, "facility", classifier.Load().Facilities())
+	if err != nil {
+		return ScheduleFilter{}, err
+	}
+
+	return filter, nil
+}
+
+func parseListParam(c *gin.Context, param string, valid []string) ([]string, error) {
+	return parseList(param, c.QueryArray(param), valid)
+}
+
+// splits each of raws on commas, lowercases and dedupes the values, and
+// checks each is one of valid
+func parseList(param string, raws []string, valid []string) ([]string, error) {
+	var values []string
+	for _, raw := range raws {
+		for _, value := range strings.Split(raw, ",") {
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value == "" {
+				continue
+			}
+
+			if !slices.Contains(valid, value) {
+				return nil, fmt.Errorf("%s %q is not valid, must be one of: %s", param, value, strings.Join(valid, ", "))
+			}
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// returns an error listing the valid gyms if gym is not a configured building
+func validateGym(gym string) error {
+	if _, exists := buildings.Get(gym); !exists {
+		return fmt.Errorf("gym %q is not valid, must be one of: %s", gym, strings.Join(buildings.Slugs(), ", "))
+	}
+	return nil
+}
+
+// returns an error listing the valid facilities if the rules can't produce facility
+func validateFacility(facility string) error {
+	facilities := classifier.Load().Facilities()
+	if !slices.Contains(facilities, facility) {
+		return fmt.Errorf("facility %q is not valid, must be one of: %s", facility, strings.Join(facilities, ", "))
+	}
+	return nil
+}
+
+// Apply returns a copy of schedule with only the selected gyms and
+// facilities, the original is left untouched
+func (f ScheduleFilter) Apply(schedule models.ScheduleResp) models.ScheduleResp {
+	filtered := make(models.ScheduleResp, len(schedule))
+	for slug, building := range schedule {
+		if len(f.Gyms) > 0 && !slices.Contains(f.Gyms, slug) {
+			continue
+		}
+
+		facilities := make(models.FacilityEvents, len(building.Facilities))
+		for facility, events := range building.Facilities {
+			if len(f.Facilities) > 0 && !slices.Contains(f.Facilities, facility) {
+				continue
+			}
+			facilities[facility] = events
+		}
+
+		availability := make(models.FacilityAvailability, len(building.Availability))
+		for facility, rooms := range building.Availability {
+			if len(f.Facilities) > 0 && !slices.Contains(f.Facilities, facility) {
+				continue
+			}
+			availability[facility] = rooms
+		}
+
+		building.Facilities = facilities
+		building.Availability = availability
+		filtered[slug] = building
+	}
+
+	return filtered
+}
+
+// returns a copy of diff with only the events of the selected gyms and facilities
+func (f ScheduleFilter) ApplyDiff(diff models.ScheduleDiff) models.ScheduleDiff {
+	selected := func(changes []models.EventChange) []models.EventChange {
+		filtered := []models.EventChange{}
+		for _, change := range changes {
+			if len(f.Gyms) > 0 && !slices.Contains(f.Gyms, change.Gym) {
+				continue
+			}
+			if len(f.Facilities) > 0 && !slices.Contains(f.Facilities, change.Facility) {
+				continue
+			}
+			filtered = append(filtered, change)
+		}
+		return filtered
+	}
+
+	return models.ScheduleDiff{
+		Added:   selected(diff.Added),
+		Removed: selected(diff.Removed),
+		Changed: selected(diff.Changed),
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DailyHours is when a building opens and closes on a day, as HH:MM in
+// Madison. A close of 24:00 is midnight at the end of the day.
+type DailyHours struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// HoursOverride replaces the regular hours for every day in [Start, End]
+// (yyyy-mm-dd, End defaults to Start), e.g. for a holiday or break. The
+// building is either Closed or open for Hours.
+type HoursOverride struct {
+	Start  string      `json:"start"`
+	End    string      `json:"end,omitempty"`
+	Closed bool        `json:"closed,omitempty"`
+	Hours  *DailyHours `json:"hours,omitempty"`
+	Note   string      `json:"note,omitempty"`
+}
+
+// BuildingHours is a building's regular weekly hours, keyed by lowercase
+// weekday, and its overrides. Later overrides take precedence. A weekday
+// missing from Weekly is closed, and a building without any hours configured
+// is treated as open all day.
+type BuildingHours struct {
+	Weekly    map[string]DailyHours `json:"weekly,omitempty"`
+	Overrides []HoursOverride       `json:"overrides,omitempty"`
+}
+
+var allDay = DailyHours{Open: "00:00", Close: "24:00"}
+
+// On returns when the building is open on date, a schedule date
+func (h BuildingHours) On(date time.Time) models.Hours {
+	day := date.Format("2006-01-02")
+
+	for i := len(h.Overrides) - 1; i >= 0; i-- {
+		override := h.Overrides[i]
+		if day < override.Start || day > override.end() {
+			continue
+		}
+
+		if override.Closed || override.Hours == nil {
+			return models.Hours{Note: override.Note}
+		}
+
+		hours := dailyHoursOn(date, *override.Hours)
+		hours.Note = override.Note
+		return hours
+	}
+
+	if len(h.Weekly) == 0 {
+		return dailyHoursOn(date, allDay)
+	}
+
+	daily, exists := h.Weekly[strings.ToLower(date.Weekday().String())]
+	if !exists {
+		return models.Hours{}
+	}
+
+	return dailyHoursOn(date, daily)
+}
+
+func (h BuildingHours) validate() error {
+	for weekday, daily := range h.Weekly {
+		if !isWeekday(weekday) {
+			return fmt.Errorf("%q is not a weekday", weekday)
+		}
+		if err := daily.validate(); err != nil {
+			return fmt.Errorf("%s: %w", weekday, err)
+		}
+	}
+
+	for i, override := range h.Overrides {
+		start, err := time.Parse("2006-01-02", override.Start)
+		if err != nil {
+			return fmt.Errorf("override %d: start must be of the form yyyy-MM-dd", i)
+		}
+		end, err := time.Parse("2006-01-02", override.end())
+		if err != nil {
+			return fmt.Errorf("override %d: end must be of the form yyyy-MM-dd", i)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("override %d: end must not be before start", i)
+		}
+
+		if override.Closed == (override.Hours != nil) {
+			return fmt.Errorf("override %d: must either be closed or have hours", i)
+		}
+		if override.Hours != nil {
+			if err := override.Hours.validate(); err != nil {
+				return fmt.Errorf("override %d: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o HoursOverride) end() string {
+	if o.End == "" {
+		return o.Start
+	}
+	return o.End
+}
+
+func (d DailyHours) validate() error {
+	openHour, openMinute, err := parseClock(d.Open)
+	if err != nil {
+		return err
+	}
+	closeHour, closeMinute, err := parseClock(d.Close)
+	if err != nil {
+		return err
+	}
+	if closeHour*60+closeMinute <= openHour*60+openMinute {
+		return fmt.Errorf("closes at %s before it opens at %s", d.Close, d.Open)
+	}
+
+	return nil
+}
+
+// the opening and closing times of a schedule date in Madison
+func dailyHoursOn(date time.Time, daily DailyHours) models.Hours {
+	// validated when the registry was loaded
+	openHour, openMinute, _ := parseClock(daily.Open)
+	closeHour, closeMinute, _ := parseClock(daily.Close)
+
+	// time.Date handles DST and normalizes a close of 24:00 to the next midnight
+	open := time.Date(date.Year(), date.Month(), date.Day(), openHour, openMinute, 0, 0, CENTRAL_TIME)
+	close := time.Date(date.Year(), date.Month(), date.Day(), closeHour, closeMinute, 0, 0, CENTRAL_TIME)
+
+	return models.Hours{Open: &open, Close: &close}
+}
+
+// parses HH:MM into hours and minutes, from 00:00 up to and including 24:00
+func parseClock(clock string) (int, int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(clock, "%2d:%2d", &hours, &minutes); err != nil || len(clock) != 5 {
+		return 0, 0, fmt.Errorf("%q must be of the form HH:MM", clock)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, 0, fmt.Errorf("%q is not a time of day", clock)
+	}
+
+	return hours, minutes, nil
+}
+
+func isWeekday(weekday string) bool {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if weekday == strings.ToLower(day.String()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RFC 5545 lines should not be longer than 75 octets, excluding the CRLF
+const icsMaxLineOctets = 75
+
+// GET /calendar/:gym/:facility.ics renders the facility's events over a
+// rolling window of days as an iCalendar feed that can be subscribed to
+func calendar(c *gin.Context) {
+	gym := c.Param("gym")
+	facility, isICS := strings.CutSuffix(c.Param("facility"), ".ics")
+	if !isICS {
+		c.JSON(http.StatusNotFound, gin.H{"error": "calendars must be requested as /calendar/{gym}/{facility}.ics"})
+		return
+	}
+
+	if err := validateGym(gym); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	building, _ := buildings.Get(gym)
+
+	if err := validateFacility(facility); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the feed covers the memo window so subscriptions are served from the memo
+	today := scheduleDate(time.Now())
+	start := today.AddDate(0, 0, -memoPolicy.DaysBehind)
+	end := today.AddDate(0, 0, memoPolicy.DaysAhead)
+
+	// a day that can't be gotten is left out rather than failing the whole
+	// feed, it shows up again once it can be fetched
+	schedules, failed := getAvailableSchedules(c.Request.Context(), start, end)
+	for _, date := range slices.Sorted(maps.Keys(failed)) {
+		log.Printf("Leaving %s out of the %s %s calendar: %v\n", date, gym, facility, failed[date])
+	}
+	if len(schedules) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	var events []models.Event
+	for _, date := range slices.Sorted(maps.Keys(schedules)) {
+		events = append(events, schedules[date].Schedule[gym].Facilities[facility]...)
+	}
+
+	calendarName := fmt.Sprintf("%s %s", building.Title, strings.ReplaceAll(facility, "_", " "))
+	// the middleware defaults every response to JSON, which c.Data will not override
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s-%s.ics\"", gym, facility))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", renderICS(calendarName, building, events, time.Now()))
+}
+
+// renders the events as an RFC 5545 VCALENDAR. UIDs are derived from the
+// building, room, and start so an event keeps its UID across refreshes.
+func renderICS(calendarName string, building Building, events []models.Event, stamp time.Time) []byte {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+
+	writeICSLine(&buf, "BEGIN:VCALENDAR")
+	writeICSLine(&buf, "VERSION:2.0")
+	writeICSLine(&buf, "PRODID:-//UWOpenRecRoster//Schedules//EN")
+	writeICSLine(&buf, "CALSCALE:GREGORIAN")
+	writeICSLine(&buf, "METHOD:PUBLISH")
+	writeICSLine(&buf, "X-WR-CALNAME:"+escapeICSText(calendarName))
+	writeICSLine(&buf, "X-WR-TIMEZONE:"+CENTRAL_TIME.String())
+
+	for _, event := range events {
+		uid := eventUID(building.Slug, event)
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+
+		writeICSLine(&buf, "BEGIN:VEVENT")
+		writeICSLine(&buf, "UID:"+uid)
+		writeICSLine(&buf, "DTSTAMP:"+formatICSTime(stamp))
+		writeICSLine(&buf, "DTSTART:"+formatICSTime(event.Start))
+		writeICSLine(&buf, "DTEND:"+formatICSTime(event.End))
+		writeICSLine(&buf, "SUMMARY:"+escapeICSText(event.Name))
+		writeICSLine(&buf, "LOCATION:"+escapeICSText(event.Location+", "+building.Title))
+		writeICSLine(&buf, "END:VEVENT")
+	}
+
+	writeICSLine(&buf, "END:VCALENDAR")
+	return buf.Bytes()
+}
+
+// a stable identifier for the booking of a room in a building at a time
+func eventUID(building string, event models.Event) string {
+	sum := sha1.Sum([]byte(building + "\x00" + event.Location + "\x00" + event.Start.UTC().Format(time.RFC3339)))
+	return hex.EncodeToString(sum[:]) + "@uwopenrecroster"
+}
+
+func formatICSTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICSText(text string) string {
+	return icsTextEscaper.Replace(text)
+}
+
+// writes the content line terminated by CRLF, folding it onto continuation
+// lines starting with a space so no line is longer than icsMaxLineOctets.
+// Lines are only split between UTF-8 characters.
+func writeICSLine(buf *bytes.Buffer, line string) {
+	limit := icsMaxLineOctets
+	for len(line) > limit {
+		split := limit
+		for split > 0 && !utf8.RuneStart(line[split]) {
+			split--
+		}
+
+		buf.WriteString(line[:split])
+		buf.WriteString("\r\n ")
+		line = line[split:]
+
+		// the leading space of a continuation line counts towards its length
+		limit = icsMaxLineOctets - 1
+	}
+
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
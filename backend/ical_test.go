@@ -0,0 +1,59 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCalendarSkipsFailedDays(t *testing.T) {
+	server := newTestRecWell(t)
+
+	previousPolicy, previousCache := memoPolicy, scheduleCache
+	memoPolicy.DaysBehind, memoPolicy.DaysAhead = 0, 1
+	scheduleCache = NewLRUScheduleCache(64)
+	t.Cleanup(func() { memoPolicy, scheduleCache = previousPolicy, previousCache })
+
+	// today is memoized, tomorrow has to be fetched from the RecWell
+	today := scheduleDate(time.Now())
+	start := time.Date(today.Year(), today.Month(), today.Day(), 6, 0, 0, 0, CENTRAL_TIME)
+	memo := models.ScheduleResp{}
+	for _, building := range buildings.Buildings {
+		memo[building.Slug] = models.BuildingSchedule{Title: building.Title, Facilities: models.FacilityEvents{}}
+	}
+	nick := memo["nick"]
+	nick.Facilities["courts"] = []models.Event{{Name: "Open Rec Basketball", Location: "Court 1", Start: start, End: start.Add(5 * time.Hour)}}
+	if err := scheduleCache.Set(models.Schedule{ScheduleDate: today, Created: time.Now(), Schedule: memo}); err != nil {
+		t.Fatalf("memoizing today: %v", err)
+	}
+
+	r := gin.New()
+	r.GET("/calendar/:gym/:facility", calendar)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calendar/nick/courts.ics", nil))
+		return w
+	}
+
+	server.SetStatus(http.StatusInternalServerError)
+	defer server.SetStatus(http.StatusOK)
+
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the days that could be gotten, body %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "SUMMARY:Open Rec Basketball") {
+		t.Errorf("calendar is missing today's event:\n%s", w.Body)
+	}
+
+	// with nothing to serve at all, the feed fails
+	scheduleCache = NewLRUScheduleCache(64)
+	if w := get(); w.Code != http.StatusInternalServerError {
+		t.Errorf("status with every day failing = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -2,123 +2,49 @@ package main
 
 import (
     "UWOpenRecRoster2-Backend/models"
+    "context"
     "time"
-    "fmt"
-    "gorm.io/gorm"
-    "errors"
     "github.com/google/uuid"
 )
 
-func log_event(userId string, sessionId string, date time.Time) (string, string, error) {
-    // validate/get/create userId
-    user, userErr := getUser(userId)
-    createdNewUser := false
-    if errors.Is(userErr, gorm.ErrRecordNotFound) {
-        user, userErr = createUser()
-        createdNewUser = true
-        if userErr != nil {
-            return "", "", fmt.Errorf("error creating new user")
-        }
-    } else if userErr != nil {
-        return "", "", fmt.Errorf("error retrieving user")
-    }
-
-    // validate/get/create sessionId
-    var session = models.Session{}
-    var sessionErr error = nil
-    if !createdNewUser {
-        session, sessionErr = getSession(sessionId)
-    }
-
-    if createdNewUser || errors.Is(sessionErr, gorm.ErrRecordNotFound) {
-        session, sessionErr = createSession(user.UserID)
-        if sessionErr != nil {
-            return "", "", fmt.Errorf("error creating new session")
-        }
-    } else if sessionErr != nil {
-        return "", "", fmt.Errorf("error retrieving session")
-    }
-    
-    // create query
-    query := models.Query{
-        SessionID: session.SessionID,
-        ScheduleDate: date,
-        QueriedTime: time.Now(),
-    }
-
-    result := DB.Create(&query)
-    if result.Error != nil {
-        return "", "", result.Error
-    }
-
-    return user.UserID, session.SessionID, nil
+// log_event queues query to be written by the analytics writer and returns
+// the user and session ids it was logged under. The caller fills in what was
+// asked for and how it was answered, the session and time are set here.
+// An invalid user id is replaced with a new one along with its session, and a
+// session that is invalid, expired, or not the user's is rotated to a new one
+// (see SessionTracker). ctx bounds looking up a session this backend hasn't
+// seen.
+// Nothing here writes to the database, the writer creates the user and
+// session if they don't exist yet when it writes the query.
+func log_event(ctx context.Context, userId string, sessionId string, query models.Query) (string, string) {
+    // validate/create userId, storing the canonical form since Postgres
+    // rejects some of the forms uuid.Parse accepts (urn:uuid:..., braces)
+    userId, valid := canonicalId(userId)
+    sessionId, _ = canonicalId(sessionId)
+    if !valid {
+        // a new user has no sessions to look up
+        userId = uuid.New().String()
+        sessionId = ""
+    }
+
+    // continue the session, or rotate to a new one if it expired. Times
+    // are stored as UTC so the reports can convert them to Madison time.
+    now := time.Now().UTC()
+    sessionId = sessions.Touch(ctx, userId, sessionId, now)
+
+    query.SessionID = sessionId
+    query.QueriedTime = now
+    analytics.Enqueue(queryEvent{UserID: userId, Query: query})
+
+    return userId, sessionId
 }
 
-func getUser(userId string) (models.User, error) {
-    if userId == "" {
-        return models.User{}, nil
-    }
-
-    var user models.User
-    result := DB.Where("user_id = ?", userId).First(&user)
-    if result.Error != nil {
-        return models.User{}, result.Error
+// canonicalId returns id in the canonical xxxxxxxx-xxxx-... form and whether
+// it is a valid UUID at all, "" if it isn't
+func canonicalId(id string) (string, bool) {
+    parsed, err := uuid.Parse(id)
+    if err != nil {
+        return "", false
     }
-
-    return user, nil
-}
-
-func createUser() (models.User, error) {
-    var user models.User
-    for i := 0; i < 10; i++ {
-        uuid := uuid.New().String()
-
-        user = models.User{
-            UserID: uuid,
-            Created: time.Now(),
-        }
-
-        result := DB.Create(&user)
-        
-        if result.Error != nil && !errors.Is(result.Error, gorm.ErrDuplicatedKey) {
-            return models.User{}, result.Error
-        }
-    }
-
-    return user, nil
-}
-
-func getSession(sessionId string) (models.Session, error) {
-    if sessionId == "" {
-        return models.Session{}, nil
-    }
-
-    var session models.Session
-    result := DB.Where("session_id = ?", sessionId).First(&session)
-    if result.Error != nil {
-        return models.Session{}, result.Error
-    }
-
-    return session, nil
-}
-
-func createSession(userId string) (models.Session, error) {
-    var session models.Session
-    for i := 0; i < 10; i++ {
-        uuid := uuid.New().String()
-
-        session = models.Session{
-            UserID: userId,
-            SessionID: uuid,
-            Created: time.Now(),
-        }
-
-        result := DB.Create(&session)
-
-        if result.Error != nil && !errors.Is(result.Error, gorm.ErrDuplicatedKey) {
-            return models.Session{}, result.Error
-        }
-    }
-
-    return session, nil
+    return parsed.String(), true
 }
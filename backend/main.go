@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"UWOpenRecRoster2-Backend/models"
@@ -19,16 +26,124 @@ var DB *gorm.DB
 func main() {
 	initDB()
 
+	var err error
+	buildings, err = loadBuildings(os.Getenv("BUILDINGS_CONFIG"))
+	if err != nil {
+		log.Fatalf("Could not load building registry: %v", err)
+	}
+
+	if err := reloadFacilityRules(); err != nil {
+		log.Fatalf("Could not load facility rules: %v", err)
+	}
+	go reloadFacilityRulesOnSIGHUP()
+
+	// point at a different EMS server (e.g. a recwelltest server) when developing offline
+	if url := os.Getenv("RECWELL_URL"); url != "" {
+		recwell = NewEMSClient(url, http.DefaultClient)
+	}
+
 	r := gin.Default()
 
 	r.Use(middleware)
 
 	r.GET("/", hello_world)
 	r.GET("/schedule", schedule)
+	r.GET("/schedule/changes", scheduleChanges)
+	r.GET("/schedule/stream", scheduleStream)
+	r.GET("/schedules", schedules)
+	r.GET("/calendar/:gym/:facility", calendar)
+	r.GET("/open", openNow)
+	r.GET("/history/schedule", historicalSchedule)
+	r.GET("/history/snapshots", listSnapshots)
+	r.GET("/history/snapshots/:id", getSnapshot)
+	r.POST("/webhooks", requireToken("WEBHOOK_TOKEN", "webhook registration"), registerWebhook)
+	r.GET("/webhooks/:id", getWebhook)
+	r.DELETE("/webhooks/:id", deleteWebhook)
+	r.GET("/webhooks/:id/dead-letters", listWebhookDeadLetters)
+
+	admin := r.Group("/admin", requireAdmin)
+	admin.GET("/analytics/users", usersReport)
+	admin.GET("/analytics/sessions", sessionsReport)
+	admin.GET("/analytics/lookahead", lookaheadReport)
+
+	memoPolicy, err = loadMemoPolicy()
+	if err != nil {
+		log.Fatalf("Could not configure memo policy: %v", err)
+	}
+
+	sessions.IdleTimeout, err = durationEnv("SESSION_IDLE_TIMEOUT", DEFAULT_SESSION_IDLE_TIMEOUT)
+	if err == nil && sessions.IdleTimeout == 0 {
+		err = errors.New("SESSION_IDLE_TIMEOUT must be positive")
+	}
+	if err != nil {
+		log.Fatalf("Could not configure sessions: %v", err)
+	}
+
+	refresher, err := newRefresher()
+	if err != nil {
+		log.Fatalf("Could not configure refresher: %v", err)
+	}
+
+	// Shut down gracefully on SIGINT/SIGTERM, letting in flight requests,
+	// refreshes, cleanups, and webhook deliveries finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		refresher.Run(ctx)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		runJanitor(ctx, memoPolicy)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		webhooks.Run(ctx)
+	}()
+
+	// the analytics writer outlives the server so the queries of requests
+	// that finish during shutdown are still written
+	analyticsCtx, stopAnalytics := context.WithCancel(context.Background())
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		analytics.Run(analyticsCtx)
+	}()
 
-	r.Run(":8000")
+	srv := &http.Server{Addr: ":8000", Handler: r}
+	// streams never end on their own, so end them for Shutdown to finish
+	srv.RegisterOnShutdown(scheduleHub.Close)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v\n", err)
+	}
+	stopAnalytics()
+	workers.Wait()
+	// fetches shared with requests and refreshes that have since finished
+	// may still be writing what they fetched
+	waitForScheduleFetches()
 }
 
+// how long in flight requests get to finish on shutdown
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func initDB() {
 	// Get environment variables (these will come from docker-compose)
 	host := os.Getenv("DB_HOST")
@@ -83,15 +198,37 @@ func initDB() {
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
 	// Auto migrate your models
-	DB.AutoMigrate(&models.User{}, &models.Session{}, &models.Query{}, &models.Schedule{})
+	DB.AutoMigrate(
+		&models.User{}, &models.Session{}, &models.Query{},
+		&models.Schedule{}, &models.ScheduleSnapshot{}, &models.ScheduleChange{},
+		&models.Webhook{}, &models.WebhookDeadLetter{},
+	)
+	// sessions from before last_seen was tracked were last seen when they started
+	DB.Model(&models.Session{}).Where("last_seen IS NULL").Update("last_seen", gorm.Expr("created"))
+
+	scheduleCache, err = newScheduleCache(DB)
+	if err != nil {
+		log.Fatalf("Could not create schedule cache: %v", err)
+	}
+	scheduleArchive = NewPostgresScheduleArchive(DB)
+	webhooks = NewWebhookDispatcher(DB, newWebhookClient())
+	analytics = NewAnalyticsWriter(DB)
+	sessions.Lookup = lookupSession(DB)
 }
 
 func middleware(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-Id, X-Session-Id")
+	c.Writer.Header().Set("Access-Control-Expose-Headers", "X-User-Id, X-Session-Id, Age, Warning")
 	c.Writer.Header().Set("Content-Type", "application/json")
 
+	// answer CORS preflights, which no route handles
+	if c.Request.Method == http.MethodOptions {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
 	c.Next()
 }
 
@@ -117,29 +254,179 @@ func schedule(c *gin.Context) {
 		return
 	}
 
-	// Attempt to get the memoized schedule from the DB
-	schedule, err := getSchedule(date)
-	if err == nil {
-		c.JSON(http.StatusOK, schedule)
+	// validate the optional gym and facility filters
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
-	} else {
-		log.Printf("Error getting %s schedule from db: %v\n", date, err)
 	}
 
-	// If we could not get the memoized schedule, attempt to fetch it.
-	// If we successfully fetch the schedule, attempt to memoize it
-	schedule, err = fetchSchedules(date)
+	// Get the memoized schedule, or fetch and memoize it if it is missing or
+	// stale, falling back to the stale copy if the RecWell can't be reached
+	result, err := getOrFetchSchedule(c.Request.Context(), parsedDate)
 	if err == nil {
-		if memoErr := memoSchedule(schedule, parsedDate); memoErr != nil {
-			log.Printf("Error on memoize of %s: %v\n", date, memoErr)
+		trackQuery(c, parsedDate, filter, result.Source)
+
+		schedule := filter.Apply(result.Schedule)
+		if result.Stale {
+			setStaleHeaders(c, result.Created)
+			schedule = markStale(schedule)
 		}
 		c.JSON(http.StatusOK, schedule)
 		return
 	}
 
-	log_event("", "", parsedDate) // TODO:
-
 	// If we fail to get the schedule and fail to fetch it, return internal server error
 	log.Printf("Error on fetch of %s: %v\n", date, err)
+	trackQuery(c, parsedDate, filter, SOURCE_ERROR)
 	c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
 }
+
+// clients identify themselves for analytics with either these cookies or
+// headers, the headers are for clients that can't send cookies cross origin
+const (
+	USER_ID_COOKIE    = "user_id"
+	SESSION_ID_COOKIE = "session_id"
+	USER_ID_HEADER    = "X-User-Id"
+	SESSION_ID_HEADER = "X-Session-Id"
+)
+
+// how long the user id cookie lasts, the session id cookie lasts as long as the session idle timeout
+const USER_ID_COOKIE_MAX_AGE = 365 * 24 * time.Hour
+
+// logs the query of date with filter, answered from source, under the
+// client's user and session, minting new ones if it doesn't have valid ones,
+// and returns their ids in both cookies and headers. The query is written in
+// the background, so analytics never fails the request and only slows it
+// down by looking up a session this backend hasn't seen (see SessionTracker).
+func trackQuery(c *gin.Context, date time.Time, filter ScheduleFilter, source string) {
+	query := models.Query{
+		ScheduleDate: date,
+		Gym:          strings.Join(filter.Gyms, ","),
+		Facility:     strings.Join(filter.Facilities, ","),
+		ClientType:   clientType(c.Request.UserAgent()),
+		Source:       source,
+	}
+	userId, sessionId := log_event(c.Request.Context(), clientId(c, USER_ID_HEADER, USER_ID_COOKIE), clientId(c, SESSION_ID_HEADER, SESSION_ID_COOKIE), query)
+
+	c.Header(USER_ID_HEADER, userId)
+	c.Header(SESSION_ID_HEADER, sessionId)
+
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(USER_ID_COOKIE, userId, int(USER_ID_COOKIE_MAX_AGE.Seconds()), "/", "", secure, true)
+	// the browser forgets the session once it would have expired anyway
+	c.SetCookie(SESSION_ID_COOKIE, sessionId, int(sessions.IdleTimeout.Seconds()), "/", "", secure, true)
+}
+
+// reads an id from header, falling back to cookie
+func clientId(c *gin.Context, header string, cookie string) string {
+	if id := c.GetHeader(header); id != "" {
+		return id
+	}
+
+	id, _ := c.Cookie(cookie)
+	return id
+}
+
+// User-Agent substrings of crawlers and of scripts and HTTP libraries, checked
+// in that order since many crawlers also claim to be Mozilla
+var (
+	botUserAgents    = []string{"bot", "crawl", "spider", "slurp", "preview"}
+	scriptUserAgents = []string{"curl", "wget", "python", "go-http-client", "okhttp", "axios", "node", "java", "httpie", "postman", "insomnia"}
+)
+
+// classifies a User-Agent as a bot, a script, or a web browser, or unknown
+// when it is none of them or missing
+func clientType(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	switch {
+	case userAgent == "":
+		return "unknown"
+	case containsAny(userAgent, botUserAgents):
+		return "bot"
+	case containsAny(userAgent, scriptUserAgents):
+		return "script"
+	case strings.HasPrefix(userAgent, "mozilla/"):
+		return "web"
+	default:
+		return "unknown"
+	}
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// tells HTTP clients the response is a stale copy fetched at created
+func setStaleHeaders(c *gin.Context, created time.Time) {
+	c.Header("Age", strconv.Itoa(int(time.Since(created).Seconds())))
+	c.Writer.Header().Add("Warning", `110 - "Response is Stale"`)
+	c.Writer.Header().Add("Warning", `111 - "Revalidation Failed"`)
+}
+
+// the most days a single /schedules request may span
+const MAX_SCHEDULE_RANGE_DAYS = 31
+
+func schedules(c *gin.Context) {
+	// Handle getting and validating the start and end query parameters
+	dateFormat := "2006-01-02"
+	start, err := time.Parse(dateFormat, c.Query("start"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	end, err := time.Parse(dateFormat, c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	if end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must not be before start"})
+		return
+	}
+
+	if end.Sub(start) >= MAX_SCHEDULE_RANGE_DAYS*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d days can be requested at once", MAX_SCHEDULE_RANGE_DAYS)})
+		return
+	}
+
+	// validate the optional gym and facility filters
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := getOrFetchSchedules(c.Request.Context(), start, end)
+	if err != nil {
+		log.Printf("Error getting %s to %s schedules: %v\n", c.Query("start"), c.Query("end"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	// the Age of the response is that of its oldest stale day
+	resp := make(map[string]models.ScheduleResp, len(results))
+	var oldestStale time.Time
+	for date, result := range results {
+		resp[date] = filter.Apply(result.Schedule)
+		if result.Stale {
+			resp[date] = markStale(resp[date])
+			if oldestStale.IsZero() || result.Created.Before(oldestStale) {
+				oldestStale = result.Created
+			}
+		}
+	}
+	if !oldestStale.IsZero() {
+		setStaleHeaders(c, oldestStale)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"UWOpenRecRoster2-Backend/recwelltest"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sets up the registries and stores main and initDB would, without a database
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	var err error
+	buildings, err = loadBuildings("")
+	if err != nil {
+		log.Fatalf("Could not load building registry: %v", err)
+	}
+	if err := reloadFacilityRules(); err != nil {
+		log.Fatalf("Could not load facility rules: %v", err)
+	}
+
+	scheduleCache = NewLRUScheduleCache(64)
+	scheduleArchive = &memoryScheduleArchive{}
+	// never run, queued queries are dropped with the test binary
+	analytics = NewAnalyticsWriter(nil)
+
+	os.Exit(m.Run())
+}
+
+// points fetchSchedules at a fake EMS server replaying recwelltest/testdata
+// for the rest of the test
+func newTestRecWell(t *testing.T) *recwelltest.Server {
+	t.Helper()
+
+	server := recwelltest.NewServer()
+	if err := server.LoadDir("recwelltest/testdata"); err != nil {
+		t.Fatalf("loading recordings: %v", err)
+	}
+
+	previous := recwell
+	recwell = NewEMSClient(server.EndpointURL(), server.Client())
+	t.Cleanup(func() {
+		recwell = previous
+		server.Close()
+	})
+
+	return server
+}
+
+// memoryScheduleArchive is a ScheduleArchive for tests that only keeps what
+// is appended to it
+type memoryScheduleArchive struct {
+	mu        sync.Mutex
+	snapshots []models.ScheduleSnapshot
+	changes   []models.ScheduleChange
+}
+
+func (a *memoryScheduleArchive) Append(snapshot models.ScheduleSnapshot) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := len(a.snapshots) - 1; i >= 0; i-- {
+		if a.snapshots[i].ScheduleDate.Equal(snapshot.ScheduleDate) {
+			if snapshot.ContentHash != "" && a.snapshots[i].ContentHash == snapshot.ContentHash {
+				return nil
+			}
+			break
+		}
+	}
+
+	snapshot.Id = len(a.snapshots) + 1
+	a.snapshots = append(a.snapshots, snapshot)
+	return nil
+}
+
+func (a *memoryScheduleArchive) AsOf(date time.Time, at time.Time) (models.ScheduleSnapshot, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := len(a.snapshots) - 1; i >= 0; i-- {
+		if a.snapshots[i].ScheduleDate.Equal(date) && !a.snapshots[i].FetchedAt.After(at) {
+			return a.snapshots[i], nil
+		}
+	}
+	return models.ScheduleSnapshot{}, ErrSnapshotNotFound
+}
+
+func (a *memoryScheduleArchive) List(date time.Time) ([]models.SnapshotSummary, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var summaries []models.SnapshotSummary
+	for _, snapshot := range a.snapshots {
+		if snapshot.ScheduleDate.Equal(date) {
+			summaries = append(summaries, models.SnapshotSummary{Id: snapshot.Id, FetchedAt: snapshot.FetchedAt})
+		}
+	}
+	return summaries, nil
+}
+
+func (a *memoryScheduleArchive) Get(id int) (models.ScheduleSnapshot, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if id < 1 || id > len(a.snapshots) {
+		return models.ScheduleSnapshot{}, ErrSnapshotNotFound
+	}
+	return a.snapshots[id-1], nil
+}
+
+func (a *memoryScheduleArchive) AppendChange(change models.ScheduleChange) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.changes = append(a.changes, change)
+	return nil
+}
+
+func (a *memoryScheduleArchive) ChangesSince(date time.Time, since time.Time) ([]models.ScheduleChange, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var changes []models.ScheduleChange
+	for _, change := range a.changes {
+		if change.ScheduleDate.Equal(date) && change.DetectedAt.After(since) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func TestMiddlewarePreflight(t *testing.T) {
+	r := gin.New()
+	r.Use(middleware)
+	r.GET("/schedule", schedule)
+
+	req := httptest.NewRequest(http.MethodOptions, "/schedule?date=2025-04-01", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "x-user-id, x-session-id")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-User-Id") || !strings.Contains(got, "X-Session-Id") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want the id headers allowed", got)
+	}
+}
@@ -2,29 +2,26 @@ package main
 
 import (
 	"UWOpenRecRoster2-Backend/models"
-	"errors"
+	"context"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
-	"gorm.io/gorm/clause"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
-// memoizes the schedule if it is in the range of [-3, 14] days from now
-// also will delete all entries that are older than 3 days
+// memoizes the schedule if date is in the memo window. Schedules that fall
+// out of the back of the window are deleted by the janitor, not here.
 func memoSchedule(schedule models.ScheduleResp, date time.Time) error {
 	log.Printf("Memoizing %v schedule\n", date)
 
-	now := time.Now()
-	twoWeeksAhead := now.AddDate(0, 0, 14)
-	threeDaysAgo := now.AddDate(0, 0, -3)
-
-	if err := DB.Where("schedule_date < ?", threeDaysAgo).Delete(&models.Schedule{}).Error; err != nil {
-		return errors.New("error deleting all schedules older than three days")
-	}
-
-	if date.After(twoWeeksAhead) || date.Before(threeDaysAgo) {
-		log.Printf("Aborting Memoize of %v, outside of memo window [-3 days, 14 days]\n", date)
+	policy := memoPolicy
+	if !policy.InWindow(date, time.Now()) {
+		log.Printf("Aborting Memoize of %v, outside of memo window [-%d days, %d days]\n", date, policy.DaysBehind, policy.DaysAhead)
 		return nil
 	}
 
@@ -34,39 +31,277 @@ func memoSchedule(schedule models.ScheduleResp, date time.Time) error {
 		Schedule:     schedule,
 	}
 
-	// Attempt to insert or update the record
-	result := DB.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "schedule_date"}},
-		DoUpdates: clause.AssignmentColumns([]string{"created", "schedule"}),
-	}).Create(&scheduleDBModel)
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to save schedule: %w", result.Error)
+	if err := scheduleCache.Set(scheduleDBModel); err != nil {
+		return fmt.Errorf("failed to save schedule: %w", err)
 	}
 
 	return nil
 }
 
-func getSchedule(date string) (models.ScheduleResp, error) {
-	var schedule models.Schedule
+// where a memoResult's schedule came from, recorded on analytics queries.
+// SOURCE_ERROR is for requests that got no schedule at all.
+const (
+	SOURCE_MEMO     = "memo"
+	SOURCE_UPSTREAM = "upstream"
+	SOURCE_STALE    = "stale"
+	SOURCE_ERROR    = "error"
+)
+
+// memoResult is a schedule, when it was fetched, and where it came from.
+// Stale is set when the schedule is past its TTL but is being served anyway
+// because the RecWell couldn't be reached.
+type memoResult struct {
+	Schedule models.ScheduleResp
+	Created  time.Time
+	Stale    bool
+	Source   string
+}
+
+// returns an error if schedule was memoized in an older format. Memos from
+// before buildings were keyed by slug still unmarshal into a ScheduleResp, but
+// with no title and no facilities, so they must not be served or diffed.
+func checkMemoFormat(schedule models.ScheduleResp) error {
+	for slug, building := range schedule {
+		if building.Title == "" || building.Facilities == nil {
+			return fmt.Errorf("memoized %s schedule is in an older format", slug)
+		}
+	}
+	return nil
+}
+
+// returns an error if the memoized schedule should be refetched
+func checkMemo(schedule models.Schedule) error {
+	if err := checkMemoFormat(schedule.Schedule); err != nil {
+		return err
+	}
+
+	// a stale schedule is still served while it is being refreshed
+	if memoPolicy.IsStale(schedule.ScheduleDate, schedule.Created, time.Now()) && !isRefreshing(schedule.ScheduleDate) {
+		return fmt.Errorf("schedule is stale")
+	}
+
+	// a building added to the registry since this was memoized needs a refetch
+	for _, slug := range buildings.Slugs() {
+		if _, exists := schedule.Schedule[slug]; !exists {
+			return fmt.Errorf("memoized schedule is missing %s", slug)
+		}
+	}
 
-	parsedDate, err := time.Parse("2006-01-02", date)
+	return nil
+}
+
+// gets the schedule of a single day, see getOrFetchSchedules
+func getOrFetchSchedule(ctx context.Context, date time.Time) (memoResult, error) {
+	schedules, err := getOrFetchSchedules(ctx, date, date)
 	if err != nil {
-		return models.ScheduleResp{}, fmt.Errorf("invalid date format: %w", err)
+		return memoResult{}, err
+	}
+
+	return schedules[date.Format("2006-01-02")], nil
+}
+
+// how many days getOrFetchSchedules fetches from the RecWell at once
+const RANGE_FETCH_CONCURRENCY = 4
+
+// gets the schedule of every day in [start, end], keyed by yyyy-mm-dd, or the
+// error of the first day that couldn't be gotten (see getAvailableSchedules)
+func getOrFetchSchedules(ctx context.Context, start time.Time, end time.Time) (map[string]memoResult, error) {
+	schedules, failed := getAvailableSchedules(ctx, start, end)
+	if len(failed) > 0 {
+		return nil, failed[slices.Min(slices.Collect(maps.Keys(failed)))]
 	}
 
-	err = DB.Where("schedule_date = ?", parsedDate).First(&schedule).Error
+	return schedules, nil
+}
+
+// gets the schedule of every day in [start, end] that can be gotten, and why
+// each of the rest couldn't be, both keyed by yyyy-mm-dd. The memoized days
+// are read with one query. Days past the soft TTL are served and refreshed in
+// the background, and the days that are missing or stale are fetched and
+// memoized a few at a time. If a stale day can't be fetched, its stale copy is
+// served instead of failing.
+func getAvailableSchedules(ctx context.Context, start time.Time, end time.Time) (map[string]memoResult, map[string]error) {
+	rows, err := scheduleCache.GetRange(start, end)
 	if err != nil {
-		return models.ScheduleResp{}, fmt.Errorf("no occurrence of schedule for %s: %w", date, err)
+		log.Printf("Error getting %v to %v schedules from the cache: %v\n", start, end, err)
+	}
+
+	// memos in an older format are treated as missing, they can't even be
+	// served stale
+	memoized := make(map[string]models.Schedule, len(rows))
+	for _, row := range rows {
+		date := row.ScheduleDate.Format("2006-01-02")
+		if err := checkMemoFormat(row.Schedule); err != nil {
+			log.Printf("Not using memoized %s schedule: %v\n", date, err)
+			continue
+		}
+		memoized[date] = row
+	}
+
+	schedules := make(map[string]memoResult)
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(RANGE_FETCH_CONCURRENCY)
+
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		row, exists := memoized[date]
+
+		if exists {
+			err := checkMemo(row)
+			if err == nil {
+				if memoPolicy.NeedsRevalidation(day, row.Created, time.Now()) {
+					revalidate(day)
+				}
+
+				mu.Lock()
+				schedules[date] = memoResult{Schedule: row.Schedule, Created: row.Created, Source: SOURCE_MEMO}
+				mu.Unlock()
+				continue
+			}
+			log.Printf("Not using memoized %s schedule: %v\n", date, err)
+		}
+
+		g.Go(func() error {
+			result := memoResult{Created: time.Now(), Source: SOURCE_UPSTREAM}
+
+			var err error
+			result.Schedule, err = fetchAndMemoSchedule(ctx, day)
+			if err != nil {
+				if !exists {
+					mu.Lock()
+					failed[date] = fmt.Errorf("error on fetch of %s: %w", date, err)
+					mu.Unlock()
+					return nil
+				}
+
+				log.Printf("Error on fetch of %s, serving the stale memo: %v\n", date, err)
+				result = memoResult{Schedule: row.Schedule, Created: row.Created, Stale: true, Source: SOURCE_STALE}
+			}
+
+			mu.Lock()
+			schedules[date] = result
+			mu.Unlock()
+			return nil
+		})
 	}
 
-	now := time.Now()
-	oneHourAgo := now.Add(-1 * time.Hour)
-	fmt.Printf("Created: %v, Now: %v\n", schedule.Created, now)
-	if schedule.Created.Before(oneHourAgo) {
-		return models.ScheduleResp{}, fmt.Errorf("schedule is stale")
+	g.Wait()
+
+	return schedules, failed
+}
+
+// returns a copy of schedule with every building marked stale
+func markStale(schedule models.ScheduleResp) models.ScheduleResp {
+	marked := make(models.ScheduleResp, len(schedule))
+	for slug, building := range schedule {
+		building.Stale = true
+		marked[slug] = building
 	}
+	return marked
+}
 
-	// get the schedule from the schedule DB object
-	return schedule.Schedule, nil
+// how many days are revalidated in the background at once, across every request
+const REVALIDATE_CONCURRENCY = 4
+
+// holds a slot for every background revalidation running
+var revalidations = make(chan struct{}, REVALIDATE_CONCURRENCY)
+
+// refreshes the schedule for date in the background, unless it already is or
+// REVALIDATE_CONCURRENCY revalidations are running. A skipped date is still
+// served, and revalidated by a later request or the refresher.
+func revalidate(date time.Time) {
+	if isRefreshing(date) {
+		return
+	}
+
+	select {
+	case revalidations <- struct{}{}:
+	default:
+		return
+	}
+
+	sharedFetches.Add(1)
+	go func() {
+		defer sharedFetches.Done()
+		defer func() { <-revalidations }()
+
+		if _, err := fetchAndMemoSchedule(context.Background(), date); err != nil {
+			log.Printf("Error revalidating %s: %v\n", date.Format("2006-01-02"), err)
+		}
+	}()
+}
+
+// coalesces concurrent fetches of the same date
+var scheduleFetches singleflight.Group
+
+// every shared fetch and background revalidation still running, which can
+// outlive whoever started them
+var sharedFetches sync.WaitGroup
+
+// waits for the shared fetches still running to archive, memoize, and notify
+// what they fetched, so shutting down doesn't cut their writes short
+func waitForScheduleFetches() {
+	sharedFetches.Wait()
+}
+
+// the yyyy-mm-dd of every date with a fetch in flight
+var refreshesInFlight sync.Map
+
+// reports whether the schedule for date is being fetched right now
+func isRefreshing(date time.Time) bool {
+	_, refreshing := refreshesInFlight.Load(date.Format("2006-01-02"))
+	return refreshing
+}
+
+// fetches the schedule for date from the RecWell, archives it and how it
+// changed, memoizes it, and publishes it to its streams. Concurrent calls for
+// the same date share a single upstream fetch and memoize. The shared fetch is
+// detached from ctx so one waiter going away doesn't fail the rest;
+// fetchSchedules' own deadline still bounds it, and waitForScheduleFetches
+// waits for it on shutdown.
+func fetchAndMemoSchedule(ctx context.Context, date time.Time) (models.ScheduleResp, error) {
+	key := date.Format("2006-01-02")
+
+	results := scheduleFetches.DoChan(key, func() (interface{}, error) {
+		sharedFetches.Add(1)
+		defer sharedFetches.Done()
+
+		refreshesInFlight.Store(key, true)
+		defer refreshesInFlight.Delete(key)
+
+		schedule, err := fetchSchedules(context.WithoutCancel(ctx), key)
+		if err != nil {
+			return nil, err
+		}
+
+		fetchedAt := time.Now()
+		archiveSchedule(schedule, date, fetchedAt)
+
+		// diff against the memo this fetch replaces, unless it is in an older
+		// format, which would make every event look added
+		update := scheduleUpdate{Date: key, Schedule: schedule}
+		if previous, err := scheduleCache.Get(date); err == nil && checkMemoFormat(previous.Schedule) == nil {
+			if change, changed := recordChanges(previous, schedule, date, fetchedAt); changed {
+				update.Change = &change
+			}
+		}
+
+		if memoErr := memoSchedule(schedule, date); memoErr != nil {
+			log.Printf("Error on memoize of %s: %v\n", key, memoErr)
+		}
+		scheduleHub.Publish(update)
+		return schedule, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(models.ScheduleResp), nil
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchAndMemoScheduleCoalesces(t *testing.T) {
+	server := newTestRecWell(t)
+	date := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	release := server.Hold()
+	defer release()
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fetchAndMemoSchedule(context.Background(), date)
+			errs <- err
+		}()
+	}
+
+	// hold the first fetch until every caller has had the chance to join it
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Requests()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no request reached the RecWell")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	release()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("fetchAndMemoSchedule: %v", err)
+		}
+	}
+
+	var buildingIds []int
+	for _, request := range server.Requests() {
+		buildingIds = append(buildingIds, request.Data.BuildingId)
+	}
+	slices.Sort(buildingIds)
+
+	var want []int
+	for _, building := range buildings.Buildings {
+		want = append(want, building.BuildingId)
+	}
+	slices.Sort(want)
+
+	if !slices.Equal(buildingIds, want) {
+		t.Errorf("requested buildings %v, want each of %v once", buildingIds, want)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"UWOpenRecRoster2-Backend/models"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -31,7 +32,15 @@ type LegacyQueries struct {
 	Facility  string
 }
 
+// the source recorded on migrated queries
+const LEGACY_SOURCE = "legacy"
+
 func main() {
+	// -backfill only fills in the gym, facility, and source of the legacy
+	// queries already in the database, leaving everything else as it is
+	backfill := flag.Bool("backfill", false, "fill in the gym and facility of already migrated queries instead of reinserting everything")
+	flag.Parse()
+
 	// legacySessionsCSVPath := "test.sessions.0000000010000.csv"
 	// legacyQueriesCSVPath := "test.queries.0000000010000.csv"
 	legacySessionsCSVPath := "sessions.dummy.csv"
@@ -43,6 +52,15 @@ func main() {
 
 	users, sessions, queries := convertData(legacySessions, legacyQueries)
 
+	if *backfill {
+		fmt.Println("Postgres Backfill...")
+
+		if err := backfillQueries(queries); err != nil {
+			log.Printf("Error backfilling queries: %v", err)
+		}
+		return
+	}
+
 	fmt.Println("Postgres Insertion...")
 
 	err = insertData(users, sessions, queries)
@@ -195,6 +213,9 @@ func convertData(legacySessions []LegacySession, legacyQueries []LegacyQueries)
 			SessionID:    legacyQuery.SessionId,
 			ScheduleDate: scheduleDate,
 			QueriedTime:  queriedTime,
+			Gym:          gym,
+			Facility:     strings.ToLower(strings.TrimSpace(legacyQuery.Facility)),
+			Source:       LEGACY_SOURCE,
 		}
 
 		queries = append(queries, query)
@@ -344,6 +365,64 @@ func insertData(users []models.User, sessions []models.Session, queries []models
 	return nil
 }
 
+// backfillQueries fills in the gym, facility, and source of the queries an
+// earlier run of the migration inserted without them. Legacy queries have no
+// ids of their own, so they are matched to rows by session, schedule date,
+// and queried time, in the order they were inserted.
+func backfillQueries(queries []models.Query) error {
+	// Connect to the database
+	dsn := getDSN()
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Add the new columns
+	if err := db.AutoMigrate(&models.Query{}); err != nil {
+		return fmt.Errorf("failed to auto migrate models: %w", err)
+	}
+
+	key := func(query models.Query) string {
+		return query.SessionID + " " + query.ScheduleDate.Format("2006-01-02") + " " + query.QueriedTime.Format("2006-01-02 15:04:05")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// the rows that haven't been backfilled, in insertion order
+		var existing []models.Query
+		if err := tx.Where("source = ''").Order("id").Find(&existing).Error; err != nil {
+			return fmt.Errorf("failed to read queries: %w", err)
+		}
+
+		keyToIds := make(map[string][]int)
+		for _, query := range existing {
+			keyToIds[key(query)] = append(keyToIds[key(query)], query.Id)
+		}
+
+		log.Printf("Backfilling %d legacy queries...", len(queries))
+		var backfilled int
+		for _, query := range queries {
+			ids := keyToIds[key(query)]
+			if len(ids) == 0 {
+				continue
+			}
+			keyToIds[key(query)] = ids[1:]
+
+			err := tx.Model(&models.Query{}).Where("id = ?", ids[0]).Updates(map[string]interface{}{
+				"gym":      query.Gym,
+				"facility": query.Facility,
+				"source":   query.Source,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to backfill query %d: %w", ids[0], err)
+			}
+			backfilled++
+		}
+
+		log.Printf("Successfully backfilled %d of %d legacy queries", backfilled, len(queries))
+		return nil
+	})
+}
+
 func getDSN() string {
 	err := godotenv.Load("../.env")
 	if err != nil {
@@ -15,18 +15,31 @@ type User struct {
 	Sessions []Session `gorm:"foreignKey:UserID"` // Has many Sessions
 }
 
+// Session is a user's visit, it lasts from Created to LastSeen and ends once
+// it has been idle for longer than the session idle timeout
 type Session struct {
-	SessionID string    `gorm:"type:uuid;unique;not null;primaryKey"`
-	UserID    string    `gorm:"type:uuid;not null"` // Belongs to User
-	Created   time.Time `gorm:"type:timestamp;not null"`
-	Queries   []Query   `gorm:"foreignKey:SessionID"` // Has many Queries
+	SessionID  string    `gorm:"type:uuid;unique;not null;primaryKey"`
+	UserID     string    `gorm:"type:uuid;not null"` // Belongs to User
+	Created    time.Time `gorm:"type:timestamp;not null"`
+	LastSeen   time.Time `gorm:"type:timestamp"`
+	QueryCount int       `gorm:"not null;default:0"`
+	Queries    []Query   `gorm:"foreignKey:SessionID"` // Has many Queries
 }
 
+// Query is a single schedule request. Gym and Facility are the filters it
+// asked for, comma separated and empty for all of them. ClientType is what
+// kind of client sent it (web, script, bot, or unknown) and Source is where
+// the schedule came from (memo, upstream, stale, or error, legacy for the
+// migrated queries).
 type Query struct {
 	Id           int       `gorm:"primaryKey;autoIncrement"`
 	SessionID    string    `gorm:"type:uuid;not null"` // Belongs to Session
 	ScheduleDate time.Time `gorm:"type:timestamp;not null"`
 	QueriedTime  time.Time `gorm:"type:timestamp;not null"`
+	Gym          string    `gorm:"type:text;not null;default:''"`
+	Facility     string    `gorm:"type:text;not null;default:''"`
+	ClientType   string    `gorm:"type:text;not null;default:''"`
+	Source       string    `gorm:"type:text;not null;default:''"`
 }
 
 type Schedule struct {
@@ -35,24 +48,201 @@ type Schedule struct {
 	Schedule     ScheduleResp  `gorm:"type:jsonb;not null"`
 }
 
-type ScheduleResp struct {
-    Bakke FacilityEvents `json:"bakke"`
-    Nick FacilityEvents `json:"nick"`
+// ScheduleSnapshot is one version of a day's schedule, as of when it was first
+// fetched. Snapshots are only ever appended, never updated or deleted.
+type ScheduleSnapshot struct {
+    Id           int          `gorm:"primaryKey;autoIncrement"`
+    ScheduleDate time.Time    `gorm:"type:date;not null;index:idx_snapshot_date_fetched,priority:1"`
+    FetchedAt    time.Time    `gorm:"type:timestamptz;not null;index:idx_snapshot_date_fetched,priority:2"`
+    Schedule     ScheduleResp `gorm:"type:jsonb;not null"`
+    // sha256 of the schedule's JSON, so a refetch that changed nothing isn't
+    // archived again
+    ContentHash  string       `gorm:"type:text"`
 }
 
-type FacilityEvents struct {
-    Courts []Event `json:"courts"`
-    Pool []Event `json:"pool"`
-    Esports []Event `json:"esports"`
-    MtMendota []Event `json:"mount_mendota"`
-    IceRink []Event `json:"ice_rink"`
+// ScheduleChange is how a day's schedule changed from the version fetched at
+// PreviousFetchedAt to the one fetched at DetectedAt
+type ScheduleChange struct {
+    Id                int          `gorm:"primaryKey;autoIncrement"`
+    ScheduleDate      time.Time    `gorm:"type:date;not null;index:idx_change_date_detected,priority:1"`
+    DetectedAt        time.Time    `gorm:"type:timestamptz;not null;index:idx_change_date_detected,priority:2"`
+    PreviousFetchedAt time.Time    `gorm:"type:timestamptz;not null"`
+    Diff              ScheduleDiff `gorm:"type:jsonb;not null"`
 }
 
+// Webhook is a URL that is POSTed the changes to the schedules of the
+// selected gyms and facilities on the days in [StartDate, EndDate]. Empty
+// Gyms or Facilities, comma separated slugs, select all of them and a null
+// StartDate or EndDate leaves that end of the range open.
+type Webhook struct {
+    WebhookID  string     `gorm:"type:uuid;unique;not null;primaryKey"`
+    URL        string     `gorm:"type:text;not null"`
+    Secret     string     `gorm:"type:text;not null"`
+    Gyms       string     `gorm:"type:text;not null"`
+    Facilities string     `gorm:"type:text;not null"`
+    StartDate  *time.Time `gorm:"type:date"`
+    EndDate    *time.Time `gorm:"type:date"`
+    Created    time.Time  `gorm:"type:timestamptz;not null"`
+}
+
+// WebhookDeadLetter is a webhook delivery that failed every attempt
+type WebhookDeadLetter struct {
+    Id         int       `gorm:"primaryKey;autoIncrement"`
+    DeliveryID string    `gorm:"type:uuid;not null"`
+    WebhookID  string    `gorm:"type:uuid;not null;index"`
+    Payload    string    `gorm:"type:jsonb;not null"`
+    Attempts   int       `gorm:"not null"`
+    LastError  string    `gorm:"type:text;not null"`
+    Created    time.Time `gorm:"type:timestamptz;not null"`
+}
+
+// ScheduleResp maps the slug of every configured building to its schedule
+type ScheduleResp map[string]BuildingSchedule
+
+type BuildingSchedule struct {
+    Title string `json:"title"`
+    Hours
+    Facilities FacilityEvents `json:"facilities"`
+    Availability FacilityAvailability `json:"availability"`
+    // set on responses served from a stale memo because the RecWell was down
+    Stale bool `json:"stale,omitempty"`
+}
+
+// Hours is when a building is open on a day, Open and Close are both null
+// when it is closed
+type Hours struct {
+    Open *time.Time `json:"open"`
+    Close *time.Time `json:"close"`
+    Note string `json:"hours_note,omitempty"`
+}
+
+// IsOpenAt reports whether the building is open at t
+func (h Hours) IsOpenAt(t time.Time) bool {
+    return h.Open != nil && h.Close != nil && !t.Before(*h.Open) && t.Before(*h.Close)
+}
+
+// FacilityAvailability maps a facility to when each of its rooms is free
+type FacilityAvailability map[string][]RoomAvailability
+
+// RoomAvailability is the open gaps between a room's bookings
+type RoomAvailability struct {
+    Room string `json:"room"`
+    Free []TimeSpan `json:"free"`
+}
+
+type TimeSpan struct {
+    Start time.Time `json:"start"`
+    End time.Time `json:"end"`
+}
+
+// FacilityEvents maps a facility (courts, pool, ...) to its events
+type FacilityEvents map[string][]Event
+
+// Start and End are in America/Chicago and marshal as RFC 3339 with its offset
 type Event struct {
     Name string `json:"name"`
     Location string `json:"location"`
-    Start string `json:"start"`
-    End string `json:"end"`
+    Start time.Time `json:"start"`
+    End time.Time `json:"end"`
+    DurationMinutes int `json:"duration_minutes"`
+}
+
+// Duration is how long the event lasts
+func (e Event) Duration() time.Duration {
+    return e.End.Sub(e.Start)
+}
+
+// OpenResp is the occupancy of every room of a facility at a point in time
+type OpenResp struct {
+    Gym string `json:"gym"`
+    Facility string `json:"facility"`
+    At time.Time `json:"at"`
+    Hours
+    // rooms are never free while the building is closed
+    BuildingOpen bool `json:"building_open"`
+    Rooms []RoomStatus `json:"rooms"`
+    // the next time any room is free, At if one is free now and null if none
+    // frees up again before the building closes
+    NextFree *time.Time `json:"next_free"`
+}
+
+// RoomStatus is whether a room (a court, a lane, ...) is free or booked. A
+// free room is free until FreeUntil, its next booking or the building closing.
+// A booked room is booked by CurrentEvents until BookedUntil.
+type RoomStatus struct {
+    Room string `json:"room"`
+    Free bool `json:"free"`
+    FreeUntil *time.Time `json:"free_until,omitempty"`
+    BookedUntil *time.Time `json:"booked_until,omitempty"`
+    CurrentEvents []Event `json:"current_events,omitempty"`
+}
+
+// SnapshotResp is a day's schedule as it was fetched at FetchedAt
+type SnapshotResp struct {
+    Id int `json:"id"`
+    Date string `json:"date"`
+    FetchedAt time.Time `json:"fetched_at"`
+    Schedule ScheduleResp `json:"schedule"`
+}
+
+// SnapshotSummary identifies a snapshot without its schedule
+type SnapshotSummary struct {
+    Id int `json:"id"`
+    FetchedAt time.Time `json:"fetched_at"`
+}
+
+// ScheduleDiff is the events added to, removed from, and changed in a schedule.
+// Events are matched by building, room, and start, so a moved event is
+// removed from its old start and added at its new one.
+type ScheduleDiff struct {
+    Added []EventChange `json:"added"`
+    Removed []EventChange `json:"removed"`
+    Changed []EventChange `json:"changed"`
+}
+
+// Empty reports whether nothing changed
+func (d ScheduleDiff) Empty() bool {
+    return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// EventChange is an event as it was Before and is After the change, Before
+// is null for added events and After is null for removed ones
+type EventChange struct {
+    Gym string `json:"gym"`
+    Facility string `json:"facility"`
+    Room string `json:"room"`
+    Start time.Time `json:"start"`
+    Before *Event `json:"before,omitempty"`
+    After *Event `json:"after,omitempty"`
+}
+
+// ChangeResp is a ScheduleChange as returned by /schedule/changes
+type ChangeResp struct {
+    DetectedAt time.Time `json:"detected_at"`
+    PreviousFetchedAt time.Time `json:"previous_fetched_at"`
+    ScheduleDiff
+}
+
+// WebhookResp is a registered webhook, Secret is only returned when it is registered
+type WebhookResp struct {
+    Id string `json:"id"`
+    URL string `json:"url"`
+    Secret string `json:"secret,omitempty"`
+    Gyms []string `json:"gym"`
+    Facilities []string `json:"facility"`
+    Start *string `json:"start"`
+    End *string `json:"end"`
+    Created time.Time `json:"created"`
+}
+
+// WebhookPayload is the body POSTed to a webhook when a schedule changes
+type WebhookPayload struct {
+    WebhookID string `json:"webhook_id"`
+    DeliveryID string `json:"delivery_id"`
+    Date string `json:"date"`
+    DetectedAt time.Time `json:"detected_at"`
+    PreviousFetchedAt time.Time `json:"previous_fetched_at"`
+    ScheduleDiff
 }
 
 // Scan implements the sql.Scanner interface for ScheduleJSON
@@ -79,3 +269,26 @@ func (s ScheduleResp) Value() (driver.Value, error) {
     return json.Marshal(s)
 }
 
+// Scan implements the sql.Scanner interface for ScheduleDiff
+func (d *ScheduleDiff) Scan(value interface{}) error {
+    if value == nil {
+        return nil
+    }
+
+    var data []byte
+    switch v := value.(type) {
+    case string:
+        data = []byte(v)
+    case []byte:
+        data = v
+    default:
+        return fmt.Errorf("unsupported type: %T", value)
+    }
+
+    return json.Unmarshal(data, d)
+}
+
+// Value implements the driver.Valuer interface for ScheduleDiff
+func (d ScheduleDiff) Value() (driver.Value, error) {
+    return json.Marshal(d)
+}
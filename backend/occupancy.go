@@ -0,0 +1,273 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"cmp"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// booking is a span of time a room is continuously booked. Overlapping and
+// back to back events in the same room are merged into one booking.
+type booking struct {
+	start  time.Time
+	end    time.Time
+	events []models.Event
+}
+
+// GET /open?gym=nick&facility=courts&at=2025-04-01T18:00:00-05:00 reports
+// which rooms of the facility are free or booked at the time (default now),
+// and when the next one frees up
+func openNow(c *gin.Context) {
+	gym := c.Query("gym")
+	if err := validateGym(gym); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	facility := c.Query("facility")
+	if err := validateFacility(facility); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	at := time.Now()
+	if c.Query("at") != "" {
+		var err error
+		at, err = time.Parse(time.RFC3339, c.Query("at"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at parameter must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+	at = at.In(CENTRAL_TIME)
+
+	schedule, err := getOrFetchSchedule(c.Request.Context(), scheduleDate(at))
+	if err != nil {
+		log.Printf("Error getting the %s schedule for %s %s: %v\n", scheduleDate(at).Format("2006-01-02"), gym, facility, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	registered, _ := buildings.Get(gym)
+	building := schedule.Schedule[gym]
+	c.JSON(http.StatusOK, occupancy(gym, facility, building.Facilities[facility], registered.RoomsOf(facility), building.Hours, at))
+}
+
+// interprets the events as bookings of their rooms and reports whether each
+// room is free or booked at the given time. The known rooms are free all day
+// unless they are booked, and nothing is free outside of the building's hours.
+func occupancy(gym string, facility string, events []models.Event, knownRooms []string, hours models.Hours, at time.Time) models.OpenResp {
+	resp := models.OpenResp{
+		Gym:          gym,
+		Facility:     facility,
+		At:           at,
+		Hours:        hours,
+		BuildingOpen: hours.IsOpenAt(at),
+		Rooms:        []models.RoomStatus{},
+	}
+
+	// before the building opens, the next free time is found from opening
+	evalAt := at
+	if hours.Open != nil && at.Before(*hours.Open) {
+		evalAt = *hours.Open
+	}
+	closed := !hours.IsOpenAt(evalAt)
+
+	rooms := groupByRoom(events, knownRooms)
+	for _, room := range slices.SortedFunc(maps.Keys(rooms), compareRooms) {
+		if closed {
+			resp.Rooms = append(resp.Rooms, models.RoomStatus{Room: room})
+			continue
+		}
+
+		status := roomStatus(room, mergeBookings(rooms[room]), evalAt)
+		if status.Free && (status.FreeUntil == nil || status.FreeUntil.After(*hours.Close)) {
+			status.FreeUntil = hours.Close
+		}
+
+		nextFree := &evalAt
+		if !status.Free {
+			nextFree = status.BookedUntil
+		}
+		if nextFree.Before(*hours.Close) && (resp.NextFree == nil || nextFree.Before(*resp.NextFree)) {
+			resp.NextFree = nextFree
+		}
+
+		if !resp.BuildingOpen {
+			status = models.RoomStatus{Room: room}
+		}
+		resp.Rooms = append(resp.Rooms, status)
+	}
+
+	// with no rooms known and nothing booked, nothing stands in the way of
+	// the facility being free as soon as the building is open
+	if len(rooms) == 0 && !closed {
+		resp.NextFree = &evalAt
+	}
+
+	return resp
+}
+
+func roomStatus(room string, bookings []booking, at time.Time) models.RoomStatus {
+	status := models.RoomStatus{Room: room, Free: true}
+
+	for _, b := range bookings {
+		if !b.end.After(at) {
+			continue
+		}
+
+		if b.start.After(at) {
+			status.FreeUntil = &b.start
+			break
+		}
+
+		// the room is booked, list the events happening right now
+		status.Free = false
+		status.BookedUntil = &b.end
+		for _, event := range b.events {
+			if !event.Start.After(at) && event.End.After(at) {
+				status.CurrentEvents = append(status.CurrentEvents, event)
+			}
+		}
+		break
+	}
+
+	return status
+}
+
+// computes when every room of every facility is free while the building is
+// open, including the known rooms, by facility, with no events, which are free
+// all day. Nothing is free on a day the building is closed.
+func computeAvailability(facilities models.FacilityEvents, knownRooms map[string][]string, hours models.Hours) models.FacilityAvailability {
+	var open, close time.Time
+	if hours.Open != nil && hours.Close != nil {
+		open, close = *hours.Open, *hours.Close
+	}
+
+	availability := make(models.FacilityAvailability, len(facilities))
+	for facility, events := range facilities {
+		rooms := groupByRoom(events, knownRooms[facility])
+
+		var roomAvailability []models.RoomAvailability
+		for _, room := range slices.SortedFunc(maps.Keys(rooms), compareRooms) {
+			roomAvailability = append(roomAvailability, models.RoomAvailability{
+				Room: room,
+				Free: freeGaps(mergeBookings(rooms[room]), open, close),
+			})
+		}
+		availability[facility] = roomAvailability
+	}
+
+	return availability
+}
+
+// the gaps between the bookings that fall within [open, close)
+func freeGaps(bookings []booking, open time.Time, close time.Time) []models.TimeSpan {
+	gaps := []models.TimeSpan{}
+	cursor := open
+
+	for _, b := range bookings {
+		if !cursor.Before(close) {
+			break
+		}
+		if b.start.After(cursor) {
+			gaps = append(gaps, models.TimeSpan{Start: cursor, End: minTime(b.start, close)})
+		}
+		if b.end.After(cursor) {
+			cursor = b.end
+		}
+	}
+
+	if cursor.Before(close) {
+		gaps = append(gaps, models.TimeSpan{Start: cursor, End: close})
+	}
+
+	return gaps
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// groups the events by the room they are in. Every one of knownRooms is
+// included, with no events if it has none.
+func groupByRoom(events []models.Event, knownRooms []string) map[string][]models.Event {
+	rooms := make(map[string][]models.Event)
+	for _, room := range knownRooms {
+		rooms[strings.TrimSpace(room)] = nil
+	}
+	for _, event := range events {
+		room := strings.TrimSpace(event.Location)
+		rooms[room] = append(rooms[room], event)
+	}
+
+	return rooms
+}
+
+// sorts one room's events and merges the ones that overlap or touch into bookings
+func mergeBookings(events []models.Event) []booking {
+	sorted := slices.SortedFunc(slices.Values(events), func(a, b models.Event) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	var bookings []booking
+	for _, event := range sorted {
+		if !event.End.After(event.Start) {
+			continue
+		}
+
+		last := len(bookings) - 1
+		if last >= 0 && !event.Start.After(bookings[last].end) {
+			if event.End.After(bookings[last].end) {
+				bookings[last].end = event.End
+			}
+			bookings[last].events = append(bookings[last].events, event)
+			continue
+		}
+
+		bookings = append(bookings, booking{
+			start:  event.Start,
+			end:    event.End,
+			events: []models.Event{event},
+		})
+	}
+
+	return bookings
+}
+
+// orders rooms naturally, so Court 2 comes before Court 10
+func compareRooms(a string, b string) int {
+	aName, aNumber := splitRoomNumber(a)
+	bName, bNumber := splitRoomNumber(b)
+
+	if c := strings.Compare(aName, bName); c != 0 {
+		return c
+	}
+	return cmp.Compare(aNumber, bNumber)
+}
+
+// splits a room into its name and trailing number, e.g. "Court 10" is ("Court ", 10)
+func splitRoomNumber(room string) (string, int) {
+	i := len(room)
+	for i > 0 && unicode.IsDigit(rune(room[i-1])) {
+		i--
+	}
+
+	number, err := strconv.Atoi(room[i:])
+	if err != nil {
+		return room, -1
+	}
+	return room[:i], number
+}
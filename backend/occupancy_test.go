@@ -0,0 +1,358 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// the building open from open to close on 2025-04-01, both "15:04"
+func testHours(open string, close string) models.Hours {
+	openAt, closeAt := testClock(open), testClock(close)
+	return models.Hours{Open: &openAt, Close: &closeAt}
+}
+
+func clockOf(t time.Time) string {
+	return t.In(CENTRAL_TIME).Format("15:04")
+}
+
+func TestMergeBookings(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []models.Event
+		want   []string
+	}{
+		{"none", nil, nil},
+		{
+			"separate",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "08:00"), testEvent("b", "Court 1", "09:00", "10:00")},
+			[]string{"06:00-08:00 (1)", "09:00-10:00 (1)"},
+		},
+		{
+			"overlapping",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "08:00"), testEvent("b", "Court 1", "07:00", "09:00")},
+			[]string{"06:00-09:00 (2)"},
+		},
+		{
+			"back to back",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "08:00"), testEvent("b", "Court 1", "08:00", "10:00")},
+			[]string{"06:00-10:00 (2)"},
+		},
+		{
+			"contained",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "12:00"), testEvent("b", "Court 1", "07:00", "08:00"), testEvent("c", "Court 1", "11:00", "13:00")},
+			[]string{"06:00-13:00 (3)"},
+		},
+		{
+			"out of order",
+			[]models.Event{testEvent("b", "Court 1", "09:00", "10:00"), testEvent("a", "Court 1", "06:00", "08:00")},
+			[]string{"06:00-08:00 (1)", "09:00-10:00 (1)"},
+		},
+		{
+			"empty and backwards events are ignored",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "06:00"), testEvent("b", "Court 1", "10:00", "09:00")},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, b := range mergeBookings(test.events) {
+			got = append(got, fmt.Sprintf("%s-%s (%d)", clockOf(b.start), clockOf(b.end), len(b.events)))
+		}
+
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: mergeBookings = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// describes a room's status as "<room> free until <time>", "<room> booked
+// until <time> by <events>", or "<room> closed"
+func describeRoom(status models.RoomStatus) string {
+	switch {
+	case status.Free && status.FreeUntil != nil:
+		return fmt.Sprintf("%s free until %s", status.Room, clockOf(*status.FreeUntil))
+	case status.Free:
+		return status.Room + " free"
+	case status.BookedUntil != nil:
+		var names []string
+		for _, event := range status.CurrentEvents {
+			names = append(names, event.Name)
+		}
+		return fmt.Sprintf("%s booked until %s by %v", status.Room, clockOf(*status.BookedUntil), names)
+	default:
+		return status.Room + " closed"
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	hours := testHours("06:00", "23:00")
+
+	tests := []struct {
+		name         string
+		events       []models.Event
+		knownRooms   []string
+		hours        models.Hours
+		at           string
+		wantOpen     bool
+		wantRooms    []string
+		wantNextFree string // "" for null
+	}{
+		{
+			name:         "nothing booked and no rooms known",
+			hours:        hours,
+			at:           "12:00",
+			wantOpen:     true,
+			wantNextFree: "12:00",
+		},
+		{
+			name:         "nothing booked",
+			knownRooms:   []string{"Court 2", "Court 1"},
+			hours:        hours,
+			at:           "12:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 1 free until 23:00", "Court 2 free until 23:00"},
+			wantNextFree: "12:00",
+		},
+		{
+			name:         "an unbooked known room is free",
+			events:       []models.Event{testEvent("Open Rec Basketball", "Court 1", "06:00", "11:00")},
+			knownRooms:   []string{"Court 1", "Court 2"},
+			hours:        hours,
+			at:           "08:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 1 booked until 11:00 by [Open Rec Basketball]", "Court 2 free until 23:00"},
+			wantNextFree: "08:00",
+		},
+		{
+			name: "every room booked",
+			events: []models.Event{
+				testEvent("Open Rec Basketball", "Court 1", "06:00", "11:00"),
+				testEvent("Club Volleyball", "Court 2", "07:00", "09:00"),
+			},
+			hours:        hours,
+			at:           "08:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 1 booked until 11:00 by [Open Rec Basketball]", "Court 2 booked until 09:00 by [Club Volleyball]"},
+			wantNextFree: "09:00",
+		},
+		{
+			name: "booked until merged bookings end",
+			events: []models.Event{
+				testEvent("Open Rec Basketball", "Court 1", "06:00", "08:00"),
+				testEvent("Club Basketball", "Court 1", "08:00", "10:00"),
+			},
+			hours:        hours,
+			at:           "07:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 1 booked until 10:00 by [Open Rec Basketball]"},
+			wantNextFree: "10:00",
+		},
+		{
+			name:         "free until the next booking",
+			events:       []models.Event{testEvent("Open Rec Basketball", "Court 1", "12:00", "14:00")},
+			hours:        hours,
+			at:           "10:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 1 free until 12:00"},
+			wantNextFree: "10:00",
+		},
+		{
+			name:         "booked past closing",
+			events:       []models.Event{testEvent("Intramural Volleyball", "Court 2", "20:00", "23:30")},
+			hours:        hours,
+			at:           "21:00",
+			wantOpen:     true,
+			wantRooms:    []string{"Court 2 booked until 23:30 by [Intramural Volleyball]"},
+			wantNextFree: "",
+		},
+		{
+			name:         "before opening",
+			events:       []models.Event{testEvent("Open Rec Basketball", "Court 1", "06:00", "07:00")},
+			knownRooms:   []string{"Court 2"},
+			hours:        hours,
+			at:           "05:00",
+			wantOpen:     false,
+			wantRooms:    []string{"Court 1 closed", "Court 2 closed"},
+			wantNextFree: "06:00",
+		},
+		{
+			name:         "before opening with nothing known",
+			hours:        hours,
+			at:           "05:00",
+			wantOpen:     false,
+			wantNextFree: "06:00",
+		},
+		{
+			name:       "after closing",
+			knownRooms: []string{"Court 1"},
+			hours:      hours,
+			at:         "23:30",
+			wantOpen:   false,
+			wantRooms:  []string{"Court 1 closed"},
+		},
+		{
+			name:       "closed all day",
+			knownRooms: []string{"Court 1"},
+			hours:      models.Hours{},
+			at:         "12:00",
+			wantOpen:   false,
+			wantRooms:  []string{"Court 1 closed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := occupancy("nick", "courts", test.events, test.knownRooms, test.hours, testClock(test.at))
+
+			if resp.BuildingOpen != test.wantOpen {
+				t.Errorf("building_open = %v, want %v", resp.BuildingOpen, test.wantOpen)
+			}
+
+			var rooms []string
+			for _, status := range resp.Rooms {
+				rooms = append(rooms, describeRoom(status))
+			}
+			if !slices.Equal(rooms, test.wantRooms) {
+				t.Errorf("rooms = %q, want %q", rooms, test.wantRooms)
+			}
+
+			var nextFree string
+			if resp.NextFree != nil {
+				nextFree = clockOf(*resp.NextFree)
+			}
+			if nextFree != test.wantNextFree {
+				t.Errorf("next_free = %q, want %q", nextFree, test.wantNextFree)
+			}
+		})
+	}
+}
+
+func describeSpans(spans []models.TimeSpan) []string {
+	described := []string{}
+	for _, span := range spans {
+		described = append(described, clockOf(span.Start)+"-"+clockOf(span.End))
+	}
+	return described
+}
+
+func TestFreeGaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []models.Event
+		want   []string
+	}{
+		{"no bookings", nil, []string{"06:00-23:00"}},
+		{
+			"between bookings",
+			[]models.Event{testEvent("a", "Court 1", "08:00", "10:00"), testEvent("b", "Court 1", "12:00", "13:00")},
+			[]string{"06:00-08:00", "10:00-12:00", "13:00-23:00"},
+		},
+		{
+			"booked from opening",
+			[]models.Event{testEvent("a", "Court 1", "06:00", "10:00")},
+			[]string{"10:00-23:00"},
+		},
+		{
+			"booked before opening",
+			[]models.Event{testEvent("a", "Court 1", "05:00", "07:00")},
+			[]string{"07:00-23:00"},
+		},
+		{
+			"booked past closing",
+			[]models.Event{testEvent("a", "Court 1", "21:00", "23:30")},
+			[]string{"06:00-21:00"},
+		},
+		{
+			"booked after closing",
+			[]models.Event{testEvent("a", "Court 1", "23:15", "23:45")},
+			[]string{"06:00-23:00"},
+		},
+		{
+			"overlapping bookings leave no gap",
+			[]models.Event{testEvent("a", "Court 1", "08:00", "10:00"), testEvent("b", "Court 1", "09:00", "11:00")},
+			[]string{"06:00-08:00", "11:00-23:00"},
+		},
+		{
+			"booked all day",
+			[]models.Event{testEvent("a", "Court 1", "05:00", "23:30")},
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		got := describeSpans(freeGaps(mergeBookings(test.events), testClock("06:00"), testClock("23:00")))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: freeGaps = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestComputeAvailability(t *testing.T) {
+	facilities := models.FacilityEvents{
+		"courts": {
+			testEvent("Open Rec Basketball", "Court 1", "08:00", "10:00"),
+			testEvent("Intramural Volleyball", "Court 10", "18:00", "22:00"),
+		},
+		"pool": nil,
+	}
+	knownRooms := map[string][]string{
+		"courts": {"Court 1", "Court 2"},
+		"pool":   {"Pool - Lane 1"},
+	}
+
+	tests := []struct {
+		name  string
+		hours models.Hours
+		want  map[string][]string
+	}{
+		{
+			name:  "open",
+			hours: testHours("06:00", "23:00"),
+			want: map[string][]string{
+				"Court 1":       {"06:00-08:00", "10:00-23:00"},
+				"Court 2":       {"06:00-23:00"},
+				"Court 10":      {"06:00-18:00", "22:00-23:00"},
+				"Pool - Lane 1": {"06:00-23:00"},
+			},
+		},
+		{
+			name:  "closed",
+			hours: models.Hours{},
+			want: map[string][]string{
+				"Court 1":       {},
+				"Court 2":       {},
+				"Court 10":      {},
+				"Pool - Lane 1": {},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			availability := computeAvailability(facilities, knownRooms, test.hours)
+
+			var courts []string
+			for _, room := range availability["courts"] {
+				courts = append(courts, room.Room)
+			}
+			if want := []string{"Court 1", "Court 2", "Court 10"}; !slices.Equal(courts, want) {
+				t.Errorf("courts = %v, want %v", courts, want)
+			}
+
+			for facility, rooms := range availability {
+				for _, room := range rooms {
+					if got := describeSpans(room.Free); !slices.Equal(got, test.want[room.Room]) {
+						t.Errorf("%s %s free = %v, want %v", facility, room.Room, got, test.want[room.Room])
+					}
+				}
+			}
+			if len(availability["pool"]) != 1 {
+				t.Errorf("pool = %v, want only Pool - Lane 1", availability["pool"])
+			}
+		})
+	}
+}
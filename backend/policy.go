@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTLTier is how long a memoized schedule stays fresh when its date is at
+// most MaxDays after today
+type TTLTier struct {
+	MaxDays int
+	TTL     time.Duration
+}
+
+// MemoPolicy decides which dates are memoized, how long each memo stays
+// fresh, and how often memos that fall out of the window are cleaned up. A
+// TTL of 0 never goes stale.
+type MemoPolicy struct {
+	DaysBehind int
+	DaysAhead  int
+	// sorted by MaxDays, dates past the last tier use its TTL
+	Tiers   []TTLTier
+	PastTTL time.Duration
+	// a memo past this fraction of its TTL is served while it is refreshed
+	SoftTTLFraction float64
+	CleanupInterval time.Duration
+}
+
+// today refreshes every 10 minutes, the rest of the window every 6 hours,
+// and past days never. Tomorrow gets its own hour so the refresher's near
+// loop keeps it fresher than the far days.
+func defaultMemoPolicy() MemoPolicy {
+	return MemoPolicy{
+		DaysBehind: 3,
+		DaysAhead:  14,
+		Tiers: []TTLTier{
+			{MaxDays: 0, TTL: 10 * time.Minute},
+			{MaxDays: 1, TTL: time.Hour},
+			{MaxDays: 7, TTL: 6 * time.Hour},
+		},
+		PastTTL:         0,
+		SoftTTLFraction: 0.25,
+		CleanupInterval: time.Hour,
+	}
+}
+
+// the policy in use, set from the environment at startup
+var memoPolicy = defaultMemoPolicy()
+
+// loads the memo policy from the environment, falling back to the defaults
+//
+//	MEMO_DAYS_BEHIND, MEMO_DAYS_AHEAD   the memo window, e.g. 3 and 14
+//	MEMO_TTLS                           tiers of max days ahead=TTL, e.g. "0=10m,1=1h,7=6h"
+//	MEMO_PAST_TTL                       the TTL of past days, e.g. "24h" or "never"
+//	MEMO_SOFT_TTL_FRACTION              e.g. 0.25
+//	MEMO_CLEANUP_INTERVAL               how often the janitor runs, e.g. "1h"
+func loadMemoPolicy() (MemoPolicy, error) {
+	policy := defaultMemoPolicy()
+	var err error
+
+	if policy.DaysBehind, err = intEnv("MEMO_DAYS_BEHIND", policy.DaysBehind); err != nil {
+		return MemoPolicy{}, err
+	}
+	if policy.DaysAhead, err = intEnv("MEMO_DAYS_AHEAD", policy.DaysAhead); err != nil {
+		return MemoPolicy{}, err
+	}
+
+	if value := os.Getenv("MEMO_TTLS"); value != "" {
+		if policy.Tiers, err = parseTTLTiers(value); err != nil {
+			return MemoPolicy{}, fmt.Errorf("MEMO_TTLS: %w", err)
+		}
+	}
+
+	if value := os.Getenv("MEMO_PAST_TTL"); value != "" {
+		if policy.PastTTL, err = parseTTL(value); err != nil {
+			return MemoPolicy{}, fmt.Errorf("MEMO_PAST_TTL: %w", err)
+		}
+	}
+
+	if value := os.Getenv("MEMO_SOFT_TTL_FRACTION"); value != "" {
+		policy.SoftTTLFraction, err = strconv.ParseFloat(value, 64)
+		if err != nil || policy.SoftTTLFraction <= 0 || policy.SoftTTLFraction > 1 {
+			return MemoPolicy{}, fmt.Errorf("MEMO_SOFT_TTL_FRACTION must be in (0, 1], found %q", value)
+		}
+	}
+
+	if policy.CleanupInterval, err = durationEnv("MEMO_CLEANUP_INTERVAL", policy.CleanupInterval); err != nil {
+		return MemoPolicy{}, err
+	}
+
+	return policy, nil
+}
+
+// InWindow reports whether date should be memoized
+func (p MemoPolicy) InWindow(date time.Time, now time.Time) bool {
+	days := daysFromToday(date, now)
+	return days >= -p.DaysBehind && days <= p.DaysAhead
+}
+
+// TTL is how long the memo of date stays fresh, 0 if it never goes stale
+func (p MemoPolicy) TTL(date time.Time, now time.Time) time.Duration {
+	days := daysFromToday(date, now)
+	if days < 0 {
+		return p.PastTTL
+	}
+
+	for _, tier := range p.Tiers {
+		if days <= tier.MaxDays {
+			return tier.TTL
+		}
+	}
+	return p.Tiers[len(p.Tiers)-1].TTL
+}
+
+// IsStale reports whether a memo of date created at created must be refetched
+func (p MemoPolicy) IsStale(date time.Time, created time.Time, now time.Time) bool {
+	ttl := p.TTL(date, now)
+	return ttl > 0 && now.Sub(created) > ttl
+}
+
+// NeedsRevalidation reports whether a memo of date created at created is past
+// its soft TTL and should be refreshed in the background
+func (p MemoPolicy) NeedsRevalidation(date time.Time, created time.Time, now time.Time) bool {
+	ttl := p.TTL(date, now)
+	return ttl > 0 && now.Sub(created) > time.Duration(float64(ttl)*p.SoftTTLFraction)
+}
+
+// deletes memos that have fallen out of the back of the window every
+// CleanupInterval until ctx is cancelled
+func runJanitor(ctx context.Context, policy MemoPolicy) {
+	if policy.CleanupInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(policy.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		cutoff := scheduleDate(time.Now()).AddDate(0, 0, -policy.DaysBehind)
+		if err := scheduleCache.DeleteBefore(cutoff); err != nil {
+			log.Printf("Error deleting schedules before %s: %v\n", cutoff.Format("2006-01-02"), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Janitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// the number of days date, a schedule date, is after today in Madison
+func daysFromToday(date time.Time, now time.Time) int {
+	return int(date.Sub(scheduleDate(now)).Hours() / 24)
+}
+
+// parses tiers of the form "0=10m,1=30m,7=1h,14=6h"
+func parseTTLTiers(value string) ([]TTLTier, error) {
+	var tiers []TTLTier
+	for _, part := range strings.Split(value, ",") {
+		days, ttl, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("%q must be of the form days=ttl", part)
+		}
+
+		maxDays, err := strconv.Atoi(days)
+		if err != nil || maxDays < 0 {
+			return nil, fmt.Errorf("%q is not a number of days", days)
+		}
+
+		duration, err := parseTTL(ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, TTLTier{MaxDays: maxDays, TTL: duration})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MaxDays < tiers[j].MaxDays })
+	return tiers, nil
+}
+
+// parses a duration, or "never" for 0
+func parseTTL(value string) (time.Duration, error) {
+	if value == "never" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("%q must be a non-negative duration like \"10m\" or \"never\"", value)
+	}
+	return ttl, nil
+}
+
+// reads a non-negative integer from the environment variable name, or fallback if it is unset
+func intEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, found %q", name, value)
+	}
+	return number, nil
+}
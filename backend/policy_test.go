@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoPolicyTTL(t *testing.T) {
+	policy := defaultMemoPolicy()
+	now := testClock("12:00")
+	today := scheduleDate(now)
+
+	tests := []struct {
+		days int
+		want time.Duration
+	}{
+		{-3, 0},
+		{-1, 0},
+		{0, 10 * time.Minute},
+		// tomorrow is refreshed more often than the rest of the week
+		{1, time.Hour},
+		{2, 6 * time.Hour},
+		{7, 6 * time.Hour},
+		// past the last tier
+		{14, 6 * time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := policy.TTL(today.AddDate(0, 0, test.days), now); got != test.want {
+			t.Errorf("TTL of %d days from today = %v, want %v", test.days, got, test.want)
+		}
+	}
+
+	// today is the Madison date, not the UTC one
+	lateEvening := testClock("23:30")
+	if got := policy.TTL(today, lateEvening); got != 10*time.Minute {
+		t.Errorf("TTL of today at 23:30 = %v, want 10m", got)
+	}
+}
+
+func TestMemoPolicyIsStale(t *testing.T) {
+	policy := defaultMemoPolicy()
+	now := testClock("12:00")
+	today := scheduleDate(now)
+
+	tests := []struct {
+		name           string
+		days           int
+		age            time.Duration
+		wantStale      bool
+		wantRevalidate bool
+	}{
+		{"fresh", 0, time.Minute, false, false},
+		{"past the soft TTL", 0, 5 * time.Minute, false, true},
+		{"past the TTL", 0, 11 * time.Minute, true, true},
+		{"tomorrow, fresh", 1, 10 * time.Minute, false, false},
+		{"tomorrow, past the soft TTL", 1, 20 * time.Minute, false, true},
+		{"tomorrow, past the TTL", 1, 61 * time.Minute, true, true},
+		{"next week, fresh", 3, time.Hour, false, false},
+		{"next week, past the soft TTL", 3, 2 * time.Hour, false, true},
+		{"next week, past the TTL", 3, 7 * time.Hour, true, true},
+		{"past days never", -1, 30 * 24 * time.Hour, false, false},
+	}
+
+	for _, test := range tests {
+		date := today.AddDate(0, 0, test.days)
+		created := now.Add(-test.age)
+
+		if got := policy.IsStale(date, created, now); got != test.wantStale {
+			t.Errorf("%s: IsStale = %v, want %v", test.name, got, test.wantStale)
+		}
+		if got := policy.NeedsRevalidation(date, created, now); got != test.wantRevalidate {
+			t.Errorf("%s: NeedsRevalidation = %v, want %v", test.name, got, test.wantRevalidate)
+		}
+	}
+}
+
+func TestParseTTLTiers(t *testing.T) {
+	tiers, err := parseTTLTiers("7=6h, 0=10m,14=never")
+	if err != nil {
+		t.Fatalf("parseTTLTiers: %v", err)
+	}
+	want := []TTLTier{{MaxDays: 0, TTL: 10 * time.Minute}, {MaxDays: 7, TTL: 6 * time.Hour}, {MaxDays: 14, TTL: 0}}
+	if !slices.Equal(tiers, want) {
+		t.Errorf("parseTTLTiers = %v, want %v", tiers, want)
+	}
+
+	for _, value := range []string{"", "10m", "x=10m", "-1=10m", "0=soon"} {
+		if _, err := parseTTLTiers(value); err == nil {
+			t.Errorf("parseTTLTiers(%q) succeeded, want an error", value)
+		}
+	}
+}
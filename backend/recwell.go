@@ -0,0 +1,71 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const RECWELL_SCHEDULES_URL string = "https://uwmadison.emscloudservice.com/web/AnonymousServersApi.aspx/CustomBrowseEvents"
+
+// RecWellClient makes the CustomBrowseEvents call that backs every schedule.
+// The real implementation talks to the RecWell's EMS server, tests can point
+// it at a recwelltest.Server instead.
+type RecWellClient interface {
+	CustomBrowseEvents(ctx context.Context, body models.RequestBody) (models.ResponseBody, error)
+}
+
+// the client used by fetchSchedules, swap it out to fetch from somewhere else
+var recwell RecWellClient = NewEMSClient(RECWELL_SCHEDULES_URL, http.DefaultClient)
+
+type emsClient struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewEMSClient returns a RecWellClient that POSTs to the EMS endpoint at url
+func NewEMSClient(url string, httpClient *http.Client) RecWellClient {
+	return &emsClient{
+		url:        url,
+		httpClient: httpClient,
+	}
+}
+
+func (e *emsClient) CustomBrowseEvents(ctx context.Context, body models.RequestBody) (models.ResponseBody, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return models.ResponseBody{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return models.ResponseBody{}, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return models.ResponseBody{}, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.ResponseBody{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.ResponseBody{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var respData models.ResponseBody
+	if err := json.Unmarshal(respBody, &respData); err != nil {
+		return models.ResponseBody{}, fmt.Errorf("error parsing JSON: %w", err)
+	}
+
+	return respData, nil
+}
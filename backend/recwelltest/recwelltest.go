@@ -0,0 +1,199 @@
+// Package recwelltest provides a fake RecWell EMS server for exercising the
+// schedule pipeline offline, in the spirit of net/http/httptest.
+package recwelltest
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// the path of the CustomBrowseEvents endpoint on the real EMS server
+const CustomBrowseEventsPath = "/web/AnonymousServersApi.aspx/CustomBrowseEvents"
+
+// recorded payloads are stored as <BuildingId>_<yyyy-mm-dd>.json
+var recordingName = regexp.MustCompile(`^(\d+)_(\d{4}-\d{2}-\d{2})\.json$`)
+
+type recordingKey struct {
+	buildingId int
+	date       string
+}
+
+// Server replays recorded CustomBrowseEvents responses keyed by the BuildingId
+// and date of the request. Requests without a recording get an empty schedule.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	recordings map[recordingKey][]byte
+	status     int
+	held       chan struct{}
+	requests   []models.RequestBody
+}
+
+// NewServer starts a fake EMS server with no recordings. Callers should call
+// Close when finished to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		recordings: make(map[recordingKey][]byte),
+		status:     http.StatusOK,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(CustomBrowseEventsPath, s.customBrowseEvents)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// EndpointURL is the URL to hand to NewEMSClient in place of RECWELL_SCHEDULES_URL
+func (s *Server) EndpointURL() string {
+	return s.URL + CustomBrowseEventsPath
+}
+
+// Record registers payload, a raw CustomBrowseEvents response body of the
+// form {"d": "..."}, as the response for buildingId on date
+func (s *Server) Record(buildingId int, date string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordings[recordingKey{buildingId, date}] = payload
+}
+
+// RecordEvents registers the events as the response for buildingId on date,
+// wrapping them the same way EMS does
+func (s *Server) RecordEvents(buildingId int, date string, events []models.EventRaw) error {
+	payload, err := encodeEvents(events)
+	if err != nil {
+		return err
+	}
+
+	s.Record(buildingId, date, payload)
+	return nil
+}
+
+// LoadDir records every <BuildingId>_<yyyy-mm-dd>.json file in dir
+func (s *Server) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read recordings directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		match := recordingName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		buildingId, err := strconv.Atoi(match[1])
+		if err != nil {
+			return fmt.Errorf("invalid building id in %s: %w", entry.Name(), err)
+		}
+
+		payload, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read recording %s: %w", entry.Name(), err)
+		}
+
+		s.Record(buildingId, match[2], payload)
+	}
+
+	return nil
+}
+
+// SetStatus makes every subsequent request fail with the given status code,
+// pass http.StatusOK to go back to replaying recordings
+func (s *Server) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = status
+}
+
+// Hold makes every subsequent request wait to be answered until the returned
+// release is called
+func (s *Server) Hold() (release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	held := make(chan struct{})
+	s.held = held
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		close(held)
+		if s.held == held {
+			s.held = nil
+		}
+	})
+}
+
+// Requests returns every request body the server has received, in order
+func (s *Server) Requests() []models.RequestBody {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.RequestBody(nil), s.requests...)
+}
+
+func (s *Server) customBrowseEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body models.RequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, body)
+	status := s.status
+	payload, ok := s.recordings[recordingKey{body.Data.BuildingId, body.Date}]
+	held := s.held
+	s.mu.Unlock()
+
+	if held != nil {
+		<-held
+	}
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	if !ok {
+		var err error
+		if payload, err = encodeEvents(nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(payload)
+}
+
+// EMS double encodes its response, the events are a JSON string inside "d"
+func encodeEvents(events []models.EventRaw) ([]byte, error) {
+	if events == nil {
+		events = []models.EventRaw{}
+	}
+
+	inner, err := json.Marshal(models.EventsRaw{Events: events})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal events: %w", err)
+	}
+
+	return json.Marshal(models.ResponseBody{Data: string(inner)})
+}
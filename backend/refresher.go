@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often the refresher checks today and tomorrow, and every other day in
+// the memo window, by default. A date is only refetched once its memo is past
+// the soft TTL of the memo policy.
+const (
+	DEFAULT_REFRESH_NEAR_INTERVAL = 10 * time.Minute
+	DEFAULT_REFRESH_FAR_INTERVAL  = 45 * time.Minute
+)
+
+// every wait is randomly lengthened or shortened by up to this fraction so
+// refreshes don't line up with each other or with other instances
+const REFRESH_JITTER = 0.1
+
+// Refresher proactively refetches and memoizes every date in the memo window
+// so users don't pay the upstream latency. Today and tomorrow, which change
+// the most and are looked at the most, are refreshed more often.
+type Refresher struct {
+	NearInterval time.Duration
+	FarInterval  time.Duration
+}
+
+// reads the refresh cadence from REFRESH_NEAR_INTERVAL and
+// REFRESH_FAR_INTERVAL (Go durations like "10m"), an interval of 0 disables
+// that half of the refresher
+func newRefresher() (*Refresher, error) {
+	near, err := durationEnv("REFRESH_NEAR_INTERVAL", DEFAULT_REFRESH_NEAR_INTERVAL)
+	if err != nil {
+		return nil, err
+	}
+
+	far, err := durationEnv("REFRESH_FAR_INTERVAL", DEFAULT_REFRESH_FAR_INTERVAL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Refresher{NearInterval: near, FarInterval: far}, nil
+}
+
+// Run refreshes until ctx is cancelled, starting with a full pass over the
+// window, and returns once both loops have stopped. A refresh's shared fetch
+// may still be memoizing what it fetched, see waitForScheduleFetches.
+func (r *Refresher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	if r.NearInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loop(ctx, r.NearInterval, nearDates)
+		}()
+	}
+
+	if r.FarInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loop(ctx, r.FarInterval, farDates)
+		}()
+	}
+
+	wg.Wait()
+	log.Println("Refresher stopped")
+}
+
+func (r *Refresher) loop(ctx context.Context, interval time.Duration, dates func(time.Time) []time.Time) {
+	for {
+		for _, date := range dates(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !refreshDue(date) {
+				continue
+			}
+
+			if _, err := fetchAndMemoSchedule(ctx, date); err != nil && ctx.Err() == nil {
+				log.Printf("Error refreshing %s: %v\n", date.Format("2006-01-02"), err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(interval)):
+		}
+	}
+}
+
+// reports whether date is missing from the memo or past its soft TTL
+func refreshDue(date time.Time) bool {
+	row, err := scheduleCache.Get(date)
+	if err != nil {
+		return true
+	}
+
+	return checkMemo(row) != nil || memoPolicy.NeedsRevalidation(date, row.Created, time.Now())
+}
+
+// today and tomorrow
+func nearDates(now time.Time) []time.Time {
+	today := scheduleDate(now)
+	return []time.Time{today, today.AddDate(0, 0, 1)}
+}
+
+// every other day in the memo window
+func farDates(now time.Time) []time.Time {
+	today := scheduleDate(now)
+
+	var dates []time.Time
+	for offset := -memoPolicy.DaysBehind; offset <= memoPolicy.DaysAhead; offset++ {
+		if offset == 0 || offset == 1 {
+			continue
+		}
+		dates = append(dates, today.AddDate(0, 0, offset))
+	}
+	return dates
+}
+
+func jitter(interval time.Duration) time.Duration {
+	return time.Duration(float64(interval) * (1 + REFRESH_JITTER*(2*rand.Float64()-1)))
+}
+
+// reads a Go duration from the environment variable name, or fallback if it is unset
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration like \"10m\", found %q", name, value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the longest range a report can cover, and the range it covers by default
+const (
+	MAX_REPORT_DAYS     = 366
+	DEFAULT_REPORT_DAYS = 30
+)
+
+// query times are stored as UTC, reports group them by day in Madison
+const localQueriedTime = "((q.queried_time AT TIME ZONE 'UTC') AT TIME ZONE 'America/Chicago')"
+
+// requireAdmin only lets through requests with ADMIN_TOKEN as their bearer
+// token. The admin endpoints are disabled while ADMIN_TOKEN is unset.
+var requireAdmin = requireToken("ADMIN_TOKEN", "admin")
+
+// requireToken only lets through requests with the value of the environment
+// variable env as their bearer token, and none while it is unset. what names
+// the token in errors.
+func requireToken(env string, what string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := os.Getenv(env)
+		if expected == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s endpoints are disabled", what)})
+			return
+		}
+
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("the %s token must be sent as a bearer token", what)})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// reportRange is the days in Madison a report covers, [Start, End)
+type reportRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// parses the optional start and end (yyyy-mm-dd, inclusive) query
+// parameters, by default the last DEFAULT_REPORT_DAYS days through today
+func parseReportRange(c *gin.Context) (reportRange, error) {
+	today := scheduleDate(time.Now())
+
+	end := today
+	if value := c.Query("end"); value != "" {
+		var err error
+		if end, err = time.Parse("2006-01-02", value); err != nil {
+			return reportRange{}, fmt.Errorf("end parameter must be of the form yyyy-MM-dd")
+		}
+	}
+
+	start := end.AddDate(0, 0, -(DEFAULT_REPORT_DAYS - 1))
+	if value := c.Query("start"); value != "" {
+		var err error
+		if start, err = time.Parse("2006-01-02", value); err != nil {
+			return reportRange{}, fmt.Errorf("start parameter must be of the form yyyy-MM-dd")
+		}
+	}
+
+	if end.Before(start) {
+		return reportRange{}, fmt.Errorf("end must not be before start")
+	}
+	if end.Sub(start) >= MAX_REPORT_DAYS*24*time.Hour {
+		return reportRange{}, fmt.Errorf("at most %d days can be reported on at once", MAX_REPORT_DAYS)
+	}
+
+	// midnight in Madison at either end, as UTC like the stored times
+	return reportRange{
+		Start: time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, CENTRAL_TIME).UTC(),
+		End:   time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, CENTRAL_TIME).UTC(),
+	}, nil
+}
+
+func (r reportRange) resp() gin.H {
+	return gin.H{
+		"start": r.Start.In(CENTRAL_TIME).Format("2006-01-02"),
+		"end":   r.End.In(CENTRAL_TIME).AddDate(0, 0, -1).Format("2006-01-02"),
+	}
+}
+
+// HistogramBucket is how many of something had Value
+type HistogramBucket struct {
+	Value int `json:"value"`
+	Count int `json:"count"`
+}
+
+// GET /admin/analytics/users?period=day|week&start=&end= returns, for every
+// day or week (starting Monday) in the range, how many users queried
+// anything, how many of them were new that period, and how many were returning
+func usersReport(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if period != "day" && period != "week" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day or week"})
+		return
+	}
+
+	reportRange, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	type row struct {
+		Period         time.Time `json:"-"`
+		Start          string    `json:"start" gorm:"-"`
+		ActiveUsers    int       `json:"active_users"`
+		NewUsers       int       `json:"new_users"`
+		ReturningUsers int       `json:"returning_users"`
+	}
+	rows := []row{}
+
+	err = DB.Raw(`
+		WITH activity AS (
+			SELECT s.user_id, date_trunc(@period, `+localQueriedTime+`) AS period
+			FROM queries q
+			JOIN sessions s ON s.session_id = q.session_id
+			WHERE q.queried_time >= @start AND q.queried_time < @end
+			GROUP BY 1, 2
+		)
+		SELECT
+			a.period,
+			COUNT(*) AS active_users,
+			COUNT(*) FILTER (WHERE date_trunc(@period, (u.created AT TIME ZONE 'UTC') AT TIME ZONE 'America/Chicago') = a.period) AS new_users,
+			COUNT(*) FILTER (WHERE date_trunc(@period, (u.created AT TIME ZONE 'UTC') AT TIME ZONE 'America/Chicago') < a.period) AS returning_users
+		FROM activity a
+		JOIN users u ON u.user_id = a.user_id
+		GROUP BY a.period
+		ORDER BY a.period`,
+		map[string]interface{}{"period": period, "start": reportRange.Start, "end": reportRange.End},
+	).Scan(&rows).Error
+	if err != nil {
+		log.Printf("Error reporting on users: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	for i := range rows {
+		rows[i].Start = rows[i].Period.Format("2006-01-02")
+	}
+
+	resp := reportRange.resp()
+	resp["period"] = period
+	resp["users"] = rows
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /admin/analytics/sessions?start=&end= returns, for the sessions
+// started in the range, how many sessions each user had, how many queries
+// each session made, and how long sessions lasted on average
+func sessionsReport(c *gin.Context) {
+	reportRange, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	args := map[string]interface{}{"start": reportRange.Start, "end": reportRange.End}
+
+	// queries are counted from the queries table rather than query_count so
+	// sessions from before it was tracked are counted too
+	const perSession = `
+		WITH per_session AS (
+			SELECT s.session_id, s.user_id, s.created, s.last_seen, COUNT(q.id) AS queries
+			FROM sessions s
+			LEFT JOIN queries q ON q.session_id = s.session_id
+			WHERE s.created >= @start AND s.created < @end
+			GROUP BY s.session_id
+		)`
+
+	var totals struct {
+		Sessions               int
+		Users                  int
+		QueriesPerSession      float64
+		AverageDurationSeconds float64
+	}
+	err = DB.Raw(perSession+`
+		SELECT
+			COUNT(*) AS sessions,
+			COUNT(DISTINCT user_id) AS users,
+			COALESCE(AVG(queries), 0) AS queries_per_session,
+			COALESCE(AVG(EXTRACT(EPOCH FROM (last_seen - created))), 0) AS average_duration_seconds
+		FROM per_session`,
+		args,
+	).Scan(&totals).Error
+	if err != nil {
+		log.Printf("Error reporting on sessions: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	sessionsPerUser := []HistogramBucket{}
+	err = DB.Raw(perSession+`
+		SELECT n AS value, COUNT(*) AS count
+		FROM (SELECT COUNT(*) AS n FROM per_session GROUP BY user_id) per_user
+		GROUP BY n
+		ORDER BY n`,
+		args,
+	).Scan(&sessionsPerUser).Error
+	if err != nil {
+		log.Printf("Error reporting on sessions per user: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	queriesPerSession := []HistogramBucket{}
+	err = DB.Raw(perSession+`
+		SELECT queries AS value, COUNT(*) AS count
+		FROM per_session
+		GROUP BY queries
+		ORDER BY queries`,
+		args,
+	).Scan(&queriesPerSession).Error
+	if err != nil {
+		log.Printf("Error reporting on queries per session: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	var averageSessionsPerUser float64
+	if totals.Users > 0 {
+		averageSessionsPerUser = float64(totals.Sessions) / float64(totals.Users)
+	}
+
+	resp := reportRange.resp()
+	resp["sessions"] = totals.Sessions
+	resp["users"] = totals.Users
+	resp["average_duration_seconds"] = totals.AverageDurationSeconds
+	resp["sessions_per_user"] = gin.H{"average": averageSessionsPerUser, "histogram": sessionsPerUser}
+	resp["queries_per_session"] = gin.H{"average": totals.QueriesPerSession, "histogram": queriesPerSession}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /admin/analytics/lookahead?start=&end= returns a histogram of how many
+// days ahead of the day they queried on (in Madison) people looked, negative
+// for days in the past
+func lookaheadReport(c *gin.Context) {
+	reportRange, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	histogram := []HistogramBucket{}
+	err = DB.Raw(`
+		SELECT q.schedule_date::date - `+localQueriedTime+`::date AS value, COUNT(*) AS count
+		FROM queries q
+		WHERE q.queried_time >= @start AND q.queried_time < @end
+		GROUP BY 1
+		ORDER BY 1`,
+		map[string]interface{}{"start": reportRange.Start, "end": reportRange.End},
+	).Scan(&histogram).Error
+	if err != nil {
+		log.Printf("Error reporting on lookahead: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	resp := reportRange.resp()
+	resp["days_ahead"] = histogram
+	c.JSON(http.StatusOK, resp)
+}
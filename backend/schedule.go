@@ -1,101 +1,84 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html"
-	"io"
-	"net/http"
-	"strings"
-    "errors"
+	"time"
+	_ "time/tzdata" // the alpine image has no zoneinfo
     "UWOpenRecRoster2-Backend/models"
-)
-
-type GymMetaData struct {
-	title   string
-	id      int
-	encrypt string
-}
 
-var (
-	bakke = GymMetaData{
-		title:   "Bakke Recreation and Wellbeing Center",
-		id:      1112,
-		encrypt: "https://uwmadison.emscloudservice.com/web/CustomBrowseEvents.aspx?data=meoZqrqZMvHKSLWaHS%2f4bjdroAMc1geNvtL12O1chw1fIP%2bOGy79Y1bkm2DPPKqmpSFHyPvFHX3LAJJHEfBPycyxctYlpcHD4rIwd%2byAtBNWXsKhJT9UDchzs%2bSc3Ze6JFHimlPlQrL2Jk7LFEkj3FoTWmA0BKzQQk0%2beDFO2IBZSiNnDXPGZQ%3d%3d",
-	}
-	nick = GymMetaData{
-		title:   "Nicholas Recreation Center",
-		id:      1109,
-		encrypt: "https://uwmadison.emscloudservice.com/web/CustomBrowseEvents.aspx?data=RtFXo1hK2Mh0UPlwkh3Aua7auJ66NvvBNBlUULUwM7vu4XjCwc5WoatHUWdz5pRofwluz9ZmHCNbHsgQ9uEDZjArIem0ShC%2fuM4gJbohNWkNGhzqKkAwrHDWzuEbcQxjHc8CzLweyL05oQ7ToCjKkM5TC%2b639V3qHwqgx1EhbWU%3d",
-	}
+	"golang.org/x/sync/errgroup"
 )
 
-const RECWELL_SCHEDULES_URL string = "https://uwmadison.emscloudservice.com/web/AnonymousServersApi.aspx/CustomBrowseEvents"
+// upper bound on how long fetching every gym's schedule may take, shared by
+// all of the concurrent upstream requests
+const RECWELL_FETCH_TIMEOUT = 10 * time.Second
+
+// fetches the schedules of every configured building concurrently. All of the
+// requests share a single deadline derived from ctx, and the first failure
+// cancels the rest.
+func fetchSchedules(ctx context.Context, date string) (models.ScheduleResp, error) {
+    ctx, cancel := context.WithTimeout(ctx, RECWELL_FETCH_TIMEOUT)
+    defer cancel()
+
+    registry := buildings
+    buildingEvents := make([]models.FacilityEvents, len(registry.Buildings))
+    g, ctx := errgroup.WithContext(ctx)
+
+    for i, building := range registry.Buildings {
+        g.Go(func() error {
+            events, err := fetchSchedule(ctx, date, building)
+            if err != nil {
+                return fmt.Errorf("error fetching the %s schedule: %w", building.Slug, err)
+            }
+            buildingEvents[i] = events
+            return nil
+        })
+    }
 
-func fetchSchedules(date string) (models.ScheduleResp, error) {
-    var schedule models.ScheduleResp
+    if err := g.Wait(); err != nil {
+        return models.ScheduleResp{}, err
+    }
 
-    for _, gym := range []string{"bakke", "nick"} {
-        gymEvents, err := fetchSchedule(date, gym);
-        if err != nil {
-            return models.ScheduleResp{}, fmt.Errorf("error fetching the %s schedule", gym)
-        }
-        
-        if gym == "bakke" {
-            schedule.Bakke = gymEvents
-        } else {
-            schedule.Nick = gymEvents
+    day, err := time.Parse("2006-01-02", date)
+    if err != nil {
+        return models.ScheduleResp{}, fmt.Errorf("invalid date %s: %w", date, err)
+    }
+
+    schedule := make(models.ScheduleResp, len(registry.Buildings))
+    for i, building := range registry.Buildings {
+        hours := building.Hours.On(day)
+        schedule[building.Slug] = models.BuildingSchedule{
+            Title:        building.Title,
+            Hours:        hours,
+            Facilities:   buildingEvents[i],
+            Availability: computeAvailability(buildingEvents[i], building.Rooms, hours),
         }
     }
 
     return schedule, nil
 }
 
-func fetchSchedule(date string, gym string) (models.FacilityEvents, error) {
-    var gymMeta GymMetaData
-
-    switch gym {
-    case "bakke":
-        gymMeta = bakke
-    case "nick":
-        gymMeta = nick
-    default:
-        return models.FacilityEvents{}, errors.New("gym must be either \"bakke\" or \"nick\"")
-    }
-
+func fetchSchedule(ctx context.Context, date string, building Building) (models.FacilityEvents, error) {
 	body := models.RequestBody{
 		Date: date,
     	Data: models.RequestData{
-			BuildingId:       gymMeta.id,
-			Title:            gymMeta.title,
+			BuildingId:       building.BuildingId,
+			Title:            building.Title,
 			Format:           0,
 			DropEventsInPast: false,
-			EncryptD:         gymMeta.encrypt,
+			EncryptD:         building.Encrypt,
 		},
 	}
 
-	jsonBody, err := json.Marshal(body)
+	resp, err := recwell.CustomBrowseEvents(ctx, body)
 	if err != nil {
-		return models.FacilityEvents{}, fmt.Errorf("failed to marshal request body: %w", err)
+		return models.FacilityEvents{}, err
 	}
 
-	resp, err := http.Post(RECWELL_SCHEDULES_URL, "application/json", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return models.FacilityEvents{}, fmt.Errorf("failed to make HTTP request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return models.FacilityEvents{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
-	}
-
-	schedule, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return models.FacilityEvents{}, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	events, err := parseSchedule(schedule)
+	events, err := parseSchedule(resp, building.Slug)
 	if err != nil {
 		return models.FacilityEvents{}, fmt.Errorf("failed to parse schedule: %w", err)
 	}
@@ -103,61 +86,96 @@ func fetchSchedule(date string, gym string) (models.FacilityEvents, error) {
     return events, nil
 }
 
-func parseSchedule(schedule []byte) (models.FacilityEvents, error) {
-	var resp models.ResponseBody
-	err := json.Unmarshal(schedule, &resp)
-	if err != nil {
-		return models.FacilityEvents{}, fmt.Errorf("error parsing JSON: %w", err)
-	}
-
+func parseSchedule(resp models.ResponseBody, building string) (models.FacilityEvents, error) {
 	var events models.EventsRaw
-	err = json.Unmarshal([]byte(resp.Data), &events)
+	err := json.Unmarshal([]byte(resp.Data), &events)
 	if err != nil {
 		return models.FacilityEvents{}, fmt.Errorf("error parsing JSON: %w", err)
 	}
 
-    var convertedEvents models.FacilityEvents = convertEventsToSchedule(events) 
+    convertedEvents, err := convertEventsToSchedule(events, building, classifier.Load())
+    if err != nil {
+        return models.FacilityEvents{}, err
+    }
 
 	return convertedEvents, nil
 }
 
-const (
-	court     = "court"
-	mtMendota = "mount mendota"
-	pool      = "pool"
-	iceRink   = "ice rink"
-	esports   = "esports"
-)
-
-func convertEventsToSchedule(events models.EventsRaw) models.FacilityEvents {
+// sorts the events into facilities using the classifier's rules. Every facility
+// the rules know about is present, and rooms no rule matches are kept under
+// UNCATEGORIZED rather than dropped.
+func convertEventsToSchedule(events models.EventsRaw, building string, classifier *FacilityClassifier) (models.FacilityEvents, error) {
     schedule := models.FacilityEvents{}
+    for _, facility := range classifier.Facilities() {
+        schedule[facility] = nil
+    }
+
     for _, eventRaw := range events.Events {
-        location := strings.ToLower(strings.TrimSpace(eventRaw.Location))
-        
-        var event models.Event = transformAndDecodeRawEvent(eventRaw)
-        
-        if strings.Contains(location, court) {
-            schedule.Courts = append(schedule.Courts, event)
-        } else if strings.Contains(location, mtMendota) {
-            schedule.MtMendota = append(schedule.MtMendota, event)
-        } else if strings.Contains(location, pool) {
-            schedule.Pool = append(schedule.Pool, event)
-        } else if strings.Contains(location, iceRink) {
-            schedule.IceRink = append(schedule.IceRink, event)
-        } else if strings.Contains(location, esports) {
-            schedule.Esports = append(schedule.Esports, event)   
+        event, err := transformAndDecodeRawEvent(eventRaw)
+        if err != nil {
+            return models.FacilityEvents{}, err
         }
+
+        facility := classifier.Classify(building, event.Location)
+        schedule[facility] = append(schedule[facility], event)
     }
 
-    return schedule
+    return schedule, nil
 }
 
-func transformAndDecodeRawEvent(event models.EventRaw) models.Event {
-    return models.Event{
+func transformAndDecodeRawEvent(event models.EventRaw) (models.Event, error) {
+    start, err := parseEMSTime(event.EventStart)
+    if err != nil {
+        return models.Event{}, fmt.Errorf("invalid start of %q: %w", event.EventName, err)
+    }
+
+    end, err := parseEMSTime(event.EventEnd)
+    if err != nil {
+        return models.Event{}, fmt.Errorf("invalid end of %q: %w", event.EventName, err)
+    }
+
+    decoded := models.Event{
         Name: html.UnescapeString(event.EventName),
         Location: html.UnescapeString(event.Location),
-        Start: event.EventStart,
-        End: event.EventEnd,
+        Start: start,
+        End: end,
     }
+    decoded.DurationMinutes = int(decoded.Duration().Minutes())
+
+    return decoded, nil
 }
 
+// all of the schedules are for Madison, so times are returned in its time zone
+var CENTRAL_TIME = mustLoadLocation("America/Chicago")
+
+// the schedule date that t falls on in Madison, at midnight UTC like the
+// parsed date query parameters
+func scheduleDate(t time.Time) time.Time {
+    local := t.In(CENTRAL_TIME)
+    return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// EMS sends GmtStart/GmtEnd as UTC without an offset, e.g. 2025-04-01T11:00:00
+var emsTimeLayouts = []string{
+    "2006-01-02T15:04:05.999999999",
+    time.RFC3339Nano,
+}
+
+// parses an EMS GmtStart/GmtEnd and converts it to central time
+func parseEMSTime(value string) (time.Time, error) {
+    for _, layout := range emsTimeLayouts {
+        if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+            return t.In(CENTRAL_TIME), nil
+        }
+    }
+
+    return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+func mustLoadLocation(name string) *time.Location {
+    location, err := time.LoadLocation(name)
+    if err != nil {
+        panic(fmt.Sprintf("failed to load time zone %s: %v", name, err))
+    }
+    return location
+}
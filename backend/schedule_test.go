@@ -0,0 +1,291 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requests path from a router serving the schedule endpoints
+func serveSchedule(t *testing.T, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := gin.New()
+	r.GET("/schedule", schedule)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestScheduleEndToEnd(t *testing.T) {
+	server := newTestRecWell(t)
+
+	w := serveSchedule(t, "/schedule?date=2025-04-01")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var resp models.ScheduleResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	// one request per building, for the requested date
+	var buildingIds []int
+	for _, request := range server.Requests() {
+		if request.Date != "2025-04-01" {
+			t.Errorf("requested date %q, want 2025-04-01", request.Date)
+		}
+		buildingIds = append(buildingIds, request.Data.BuildingId)
+	}
+	slices.Sort(buildingIds)
+	if !slices.Equal(buildingIds, []int{1109, 1112}) {
+		t.Errorf("requested buildings %v, want [1109 1112]", buildingIds)
+	}
+
+	nick, exists := resp["nick"]
+	if !exists {
+		t.Fatalf("response has no nick, got %v", slices.Sorted(maps.Keys(resp)))
+	}
+	if nick.Title != "Nicholas Recreation Center" {
+		t.Errorf("nick title = %q", nick.Title)
+	}
+
+	wantCounts := map[string]map[string]int{
+		"nick":  {"courts": 2, "pool": 2, "mount_mendota": 1, "ice_rink": 0, "esports": 0},
+		"bakke": {"courts": 2, "pool": 1, "mount_mendota": 0, "ice_rink": 1, "esports": 1},
+	}
+	for slug, facilities := range wantCounts {
+		for facility, want := range facilities {
+			if got := len(resp[slug].Facilities[facility]); got != want {
+				t.Errorf("%s %s has %d events, want %d", slug, facility, got, want)
+			}
+		}
+	}
+
+	// 11:00 to 16:00 UTC is 06:00 to 11:00 in Madison, which is on CDT in April
+	court := nick.Facilities["courts"][0]
+	if court.Location != "Court 1" || court.Name != "Open Rec Basketball" {
+		t.Errorf("first nick court event = %+v", court)
+	}
+	wantStart := time.Date(2025, 4, 1, 6, 0, 0, 0, CENTRAL_TIME)
+	if !court.Start.Equal(wantStart) || court.Start.In(CENTRAL_TIME).Hour() != 6 {
+		t.Errorf("court start = %v, want %v", court.Start, wantStart)
+	}
+	if court.DurationMinutes != 300 {
+		t.Errorf("court duration = %d minutes, want 300", court.DurationMinutes)
+	}
+
+	// EMS HTML escapes names
+	if name := nick.Facilities["mount_mendota"][0].Name; name != "Club Climbing & Bouldering" {
+		t.Errorf("climbing event name = %q", name)
+	}
+
+	if w.Header().Get(USER_ID_HEADER) == "" || w.Header().Get(SESSION_ID_HEADER) == "" {
+		t.Errorf("response is missing the analytics id headers")
+	}
+}
+
+func TestScheduleFiltered(t *testing.T) {
+	newTestRecWell(t)
+
+	w := serveSchedule(t, "/schedule?date=2025-04-01&gym=nick&facility=courts")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var resp models.ScheduleResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	if _, exists := resp["bakke"]; exists || len(resp) != 1 {
+		t.Errorf("response has buildings %v, want only nick", slices.Sorted(maps.Keys(resp)))
+	}
+	if facilities := resp["nick"].Facilities; len(facilities) != 1 || len(facilities["courts"]) != 2 {
+		t.Errorf("nick facilities = %v, want only its 2 court events", facilities)
+	}
+}
+
+func TestScheduleErrors(t *testing.T) {
+	server := newTestRecWell(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		upstream   int
+		wantStatus int
+	}{
+		{"missing date", "/schedule", http.StatusOK, http.StatusBadRequest},
+		{"malformed date", "/schedule?date=04-01-2025", http.StatusOK, http.StatusBadRequest},
+		{"unknown gym", "/schedule?date=2025-04-01&gym=shell", http.StatusOK, http.StatusBadRequest},
+		{"unknown facility", "/schedule?date=2025-04-01&facility=sauna", http.StatusOK, http.StatusBadRequest},
+		// nothing is memoized for a day this far back, so there is nothing stale to serve
+		{"upstream down", "/schedule?date=2025-04-02", http.StatusInternalServerError, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server.SetStatus(test.upstream)
+			defer server.SetStatus(http.StatusOK)
+
+			if w := serveSchedule(t, test.path); w.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d, body %s", w.Code, test.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestScheduleServesStaleOnUpstreamError(t *testing.T) {
+	server := newTestRecWell(t)
+
+	previous := scheduleCache
+	scheduleCache = NewLRUScheduleCache(64)
+	t.Cleanup(func() { scheduleCache = previous })
+
+	// today's memo, well past its TTL
+	today := scheduleDate(time.Now())
+	memo := models.ScheduleResp{}
+	for _, building := range buildings.Buildings {
+		memo[building.Slug] = models.BuildingSchedule{Title: building.Title, Facilities: models.FacilityEvents{}}
+	}
+	created := time.Now().Add(-time.Hour)
+	if err := scheduleCache.Set(models.Schedule{ScheduleDate: today, Created: created, Schedule: memo}); err != nil {
+		t.Fatalf("memoizing today: %v", err)
+	}
+
+	server.SetStatus(http.StatusInternalServerError)
+	defer server.SetStatus(http.StatusOK)
+
+	w := serveSchedule(t, "/schedule?date="+today.Format("2006-01-02"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the stale memo, body %s", w.Code, w.Body)
+	}
+
+	var resp models.ScheduleResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	for slug, building := range resp {
+		if !building.Stale {
+			t.Errorf("%s isn't marked stale", slug)
+		}
+	}
+	if len(resp) != len(buildings.Buildings) {
+		t.Errorf("response has %d buildings, want %d", len(resp), len(buildings.Buildings))
+	}
+
+	if age, err := strconv.Atoi(w.Header().Get("Age")); err != nil || age < 3600 {
+		t.Errorf("Age = %q, want at least 3600", w.Header().Get("Age"))
+	}
+	want := []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`}
+	if got := w.Header().Values("Warning"); !slices.Equal(got, want) {
+		t.Errorf("Warning = %q, want %q", got, want)
+	}
+}
+
+func TestParseEMSTime(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantWall   string
+		wantOffset int // hours from UTC
+	}{
+		{"2025-04-01T11:00:00", "2025-04-01 06:00", -5},
+		{"2025-01-15T18:00:00", "2025-01-15 12:00", -6},
+		{"2025-04-01T11:00:00.5", "2025-04-01 06:00", -5},
+		{"2025-04-01T11:00:00Z", "2025-04-01 06:00", -5},
+		{"2025-04-01T06:00:00-05:00", "2025-04-01 06:00", -5},
+		// Madison springs forward at 2am CST on 2025-03-09, 08:00 UTC
+		{"2025-03-09T07:30:00", "2025-03-09 01:30", -6},
+		{"2025-03-09T08:00:00", "2025-03-09 03:00", -5},
+		{"2025-03-09T08:30:00", "2025-03-09 03:30", -5},
+		// and falls back at 2am CDT on 2025-11-02, 07:00 UTC, so 1:30 happens twice
+		{"2025-11-02T06:30:00", "2025-11-02 01:30", -5},
+		{"2025-11-02T07:30:00", "2025-11-02 01:30", -6},
+		// late evening in Madison is already the next day in UTC
+		{"2025-04-02T03:00:00", "2025-04-01 22:00", -5},
+	}
+
+	for _, test := range tests {
+		got, err := parseEMSTime(test.value)
+		if err != nil {
+			t.Errorf("parseEMSTime(%q) failed: %v", test.value, err)
+			continue
+		}
+
+		if got.Location() != CENTRAL_TIME {
+			t.Errorf("parseEMSTime(%q) is in %v, want America/Chicago", test.value, got.Location())
+		}
+		if wall := got.Format("2006-01-02 15:04"); wall != test.wantWall {
+			t.Errorf("parseEMSTime(%q) = %s, want %s", test.value, wall, test.wantWall)
+		}
+		if _, offset := got.Zone(); offset != test.wantOffset*60*60 {
+			t.Errorf("parseEMSTime(%q) offset = %ds, want %dh", test.value, offset, test.wantOffset)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "2025-04-01 11:00:00", "2025-04-01"} {
+		if got, err := parseEMSTime(value); err == nil {
+			t.Errorf("parseEMSTime(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestEventDurationAcrossDST(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  string
+		wantMinutes int
+	}{
+		// 1:00 CST to 4:00 CDT on the wall clock, but only two hours pass
+		{"spring forward", "2025-03-09T07:00:00", "2025-03-09T09:00:00", 120},
+		// 1:00 CDT to 2:00 CST, the 1 o'clock hour happens twice
+		{"fall back", "2025-11-02T06:00:00", "2025-11-02T08:00:00", 120},
+		{"across midnight", "2025-04-01T23:00:00", "2025-04-02T03:00:00", 240},
+	}
+
+	for _, test := range tests {
+		event, err := transformAndDecodeRawEvent(models.EventRaw{
+			EventName:  "Open Rec Basketball",
+			Location:   "Court 1",
+			EventStart: test.start,
+			EventEnd:   test.end,
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if event.DurationMinutes != test.wantMinutes {
+			t.Errorf("%s: duration = %d minutes, want %d", test.name, event.DurationMinutes, test.wantMinutes)
+		}
+	}
+}
+
+func TestScheduleDate(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2025, 4, 2, 3, 0, 0, 0, time.UTC), "2025-04-01"},
+		{time.Date(2025, 4, 2, 5, 0, 0, 0, time.UTC), "2025-04-02"},
+		{time.Date(2025, 1, 2, 5, 59, 0, 0, time.UTC), "2025-01-01"},
+		{time.Date(2025, 1, 2, 6, 0, 0, 0, time.UTC), "2025-01-02"},
+	}
+
+	for _, test := range tests {
+		got := scheduleDate(test.at)
+		if got.Format("2006-01-02") != test.want || got.Location() != time.UTC || got.Hour() != 0 {
+			t.Errorf("scheduleDate(%v) = %v, want %s at midnight UTC", test.at, got, test.want)
+		}
+	}
+}
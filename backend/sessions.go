@@ -0,0 +1,115 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// how long a session can go without a query before the next one starts a
+// new session, overridden with SESSION_IDLE_TIMEOUT
+const DEFAULT_SESSION_IDLE_TIMEOUT = 30 * time.Minute
+
+// how long a request waits on the sessions table for a session this backend
+// hasn't seen before giving up and rotating it
+const SESSION_LOOKUP_TIMEOUT = 100 * time.Millisecond
+
+type sessionState struct {
+	UserID   string
+	LastSeen time.Time
+}
+
+// SessionTracker decides which session each query belongs to. It remembers
+// when every active session was last seen in memory so requests only wait on
+// the sessions table the first time a session is seen, e.g. after the backend
+// restarts or when another replica started it, and then for at most
+// SESSION_LOOKUP_TIMEOUT. A session Lookup doesn't find in time is rotated
+// like an expired one, so sessions never outlive their idle timeout.
+type SessionTracker struct {
+	IdleTimeout time.Duration
+	// finds a session this tracker hasn't seen, nil to only use memory
+	Lookup func(ctx context.Context, sessionId string) (sessionState, bool)
+
+	mu        sync.Mutex
+	sessions  map[string]sessionState
+	lastSweep time.Time
+}
+
+// the tracker log_event asks for sessions, its timeout is set in main
+var sessions = NewSessionTracker(DEFAULT_SESSION_IDLE_TIMEOUT)
+
+func NewSessionTracker(idleTimeout time.Duration) *SessionTracker {
+	return &SessionTracker{
+		IdleTimeout: idleTimeout,
+		sessions:    make(map[string]sessionState),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Touch returns the session a query of userId's at at belongs to:
+// sessionId, unless it is unknown, has been idle for longer than
+// IdleTimeout, or belongs to another user, in which case it is rotated to a
+// new session. ctx bounds the lookup of a session it hasn't seen.
+func (t *SessionTracker) Touch(ctx context.Context, userId string, sessionId string, at time.Time) string {
+	t.mu.Lock()
+	_, exists := t.sessions[sessionId]
+	t.mu.Unlock()
+
+	// look up sessions from before a restart or from another replica without
+	// holding the lock. Queries still waiting to be written by the analytics
+	// writer are only in sessions this tracker already knows.
+	var stored sessionState
+	var found bool
+	if !exists && sessionId != "" && t.Lookup != nil {
+		lookupCtx, cancel := context.WithTimeout(ctx, SESSION_LOOKUP_TIMEOUT)
+		stored, found = t.Lookup(lookupCtx, sessionId)
+		cancel()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.sessions[sessionId]
+	if !exists {
+		state, exists = stored, found
+	}
+	if !exists || state.UserID != userId || at.Sub(state.LastSeen) > t.IdleTimeout {
+		sessionId = uuid.New().String()
+	}
+	t.sessions[sessionId] = sessionState{UserID: userId, LastSeen: at}
+
+	// forget expired sessions now and then so the map doesn't grow forever
+	if at.Sub(t.lastSweep) > t.IdleTimeout {
+		for id, state := range t.sessions {
+			if at.Sub(state.LastSeen) > t.IdleTimeout {
+				delete(t.sessions, id)
+			}
+		}
+		t.lastSweep = at
+	}
+
+	return sessionId
+}
+
+// looks sessions up in db's sessions table, treating any error, including
+// running out of time, as not found
+func lookupSession(db *gorm.DB) func(ctx context.Context, sessionId string) (sessionState, bool) {
+	return func(ctx context.Context, sessionId string) (sessionState, bool) {
+		var session models.Session
+		result := db.WithContext(ctx).Select("user_id", "last_seen").Where("session_id = ?", sessionId).Limit(1).Find(&session)
+		if result.Error != nil {
+			log.Printf("Error looking up session %s: %v\n", sessionId, result.Error)
+			return sessionState{}, false
+		}
+		if result.RowsAffected == 0 {
+			return sessionState{}, false
+		}
+
+		return sessionState{UserID: session.UserID, LastSeen: session.LastSeen}, true
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionTrackerTouch(t *testing.T) {
+	const alice, bob = "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"
+	start := testClock("12:00")
+
+	// sessions another replica, or this one before a restart, started
+	stored := map[string]sessionState{
+		"stored-active":  {UserID: alice, LastSeen: start.Add(-10 * time.Minute)},
+		"stored-expired": {UserID: alice, LastSeen: start.Add(-time.Hour)},
+	}
+	lookups := 0
+	lookup := func(ctx context.Context, sessionId string) (sessionState, bool) {
+		lookups++
+		state, found := stored[sessionId]
+		return state, found
+	}
+
+	tests := []struct {
+		name       string
+		lookup     func(context.Context, string) (sessionState, bool)
+		sessionId  string
+		userId     string
+		at         time.Time
+		wantRotate bool
+	}{
+		{"active", nil, "active", alice, start.Add(29 * time.Minute), false},
+		{"expired", nil, "active", alice, start.Add(31 * time.Minute), true},
+		{"another user's", nil, "active", bob, start.Add(time.Minute), true},
+		{"unknown", nil, "unknown", alice, start, true},
+		{"none", nil, "", alice, start, true},
+		{"active in the table", lookup, "stored-active", alice, start, false},
+		{"expired in the table", lookup, "stored-expired", alice, start, true},
+		{"another user's in the table", lookup, "stored-active", bob, start, true},
+		{"unknown to the table", lookup, "unknown", alice, start, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := NewSessionTracker(30 * time.Minute)
+			tracker.Lookup = test.lookup
+			tracker.sessions["active"] = sessionState{UserID: alice, LastSeen: start}
+
+			got := tracker.Touch(context.Background(), test.userId, test.sessionId, test.at)
+			if rotated := got != test.sessionId; rotated != test.wantRotate {
+				t.Errorf("Touch(%s, %q) = %q, rotated = %v, want %v", test.userId, test.sessionId, got, rotated, test.wantRotate)
+			}
+			if got == "" {
+				t.Errorf("Touch returned an empty session id")
+			}
+		})
+	}
+
+	// once looked up, a session is remembered
+	tracker := NewSessionTracker(30 * time.Minute)
+	tracker.Lookup = lookup
+	lookups = 0
+	tracker.Touch(context.Background(), alice, "stored-active", start)
+	tracker.Touch(context.Background(), alice, "stored-active", start.Add(time.Minute))
+	if lookups != 1 {
+		t.Errorf("looked up a session %d times, want 1", lookups)
+	}
+}
+
+func TestSessionTrackerSlowLookup(t *testing.T) {
+	const alice = "11111111-1111-1111-1111-111111111111"
+	at := testClock("12:00")
+
+	tracker := NewSessionTracker(30 * time.Minute)
+	tracker.Lookup = func(ctx context.Context, sessionId string) (sessionState, bool) {
+		// a sessions table that doesn't answer in time
+		<-ctx.Done()
+		return sessionState{}, false
+	}
+
+	start := time.Now()
+	if got := tracker.Touch(context.Background(), alice, "stored", at); got == "stored" {
+		t.Errorf("Touch kept a session it couldn't look up")
+	}
+	if waited := time.Since(start); waited > 10*SESSION_LOOKUP_TIMEOUT {
+		t.Errorf("Touch waited %v on the lookup, want about %v", waited, SESSION_LOOKUP_TIMEOUT)
+	}
+
+	// nor past the request going away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	tracker.Touch(ctx, alice, "other", at)
+	if waited := time.Since(start); waited > SESSION_LOOKUP_TIMEOUT {
+		t.Errorf("Touch waited %v on the lookup of a cancelled request", waited)
+	}
+}
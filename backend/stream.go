@@ -0,0 +1,182 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many updates a stream can fall behind by before its oldest are dropped
+const STREAM_CLIENT_BUFFER = 8
+
+// how often an idle stream is sent a ping so proxies don't close it
+const STREAM_KEEPALIVE = 30 * time.Second
+
+// scheduleUpdate is a freshly fetched schedule for a date, and how it changed
+// from the previous memo if it did
+type scheduleUpdate struct {
+	Date     string
+	Schedule models.ScheduleResp
+	Change   *models.ScheduleChange
+}
+
+// ScheduleHub fans every schedule update out to the streams subscribed to its
+// date. Each subscriber has its own buffer, and a subscriber that falls
+// behind loses its oldest updates rather than blocking the others.
+type ScheduleHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan scheduleUpdate]struct{}
+	closed      bool
+}
+
+// the hub every fetch is published to
+var scheduleHub = NewScheduleHub()
+
+func NewScheduleHub() *ScheduleHub {
+	return &ScheduleHub{subscribers: make(map[string]map[chan scheduleUpdate]struct{})}
+}
+
+// Subscribe returns a channel of the updates to date, yyyy-mm-dd, which is
+// closed when the hub is. It must be passed to Unsubscribe when done.
+func (h *ScheduleHub) Subscribe(date string) chan scheduleUpdate {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	updates := make(chan scheduleUpdate, STREAM_CLIENT_BUFFER)
+	if h.closed {
+		close(updates)
+		return updates
+	}
+
+	if h.subscribers[date] == nil {
+		h.subscribers[date] = make(map[chan scheduleUpdate]struct{})
+	}
+	h.subscribers[date][updates] = struct{}{}
+	return updates
+}
+
+func (h *ScheduleHub) Unsubscribe(date string, updates chan scheduleUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.subscribers[date][updates]; !exists {
+		return
+	}
+
+	delete(h.subscribers[date], updates)
+	if len(h.subscribers[date]) == 0 {
+		delete(h.subscribers, date)
+	}
+	close(updates)
+}
+
+// Publish sends update to every subscriber of its date without blocking
+func (h *ScheduleHub) Publish(update scheduleUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for updates := range h.subscribers[update.Date] {
+		select {
+		case updates <- update:
+		default:
+			// full, drop the oldest update to make room. Only Publish sends
+			// and it holds the lock, so the room can't be taken back.
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- update
+		}
+	}
+}
+
+// Close ends every stream, for shutting down
+func (h *ScheduleHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for date, subscribers := range h.subscribers {
+		for updates := range subscribers {
+			close(updates)
+		}
+		delete(h.subscribers, date)
+	}
+}
+
+// GET /schedule/stream?date=yyyy-mm-dd streams a day's schedule as
+// Server-Sent Events. A schedule event with the current schedule is sent
+// first, then another every time the schedule is refetched, preceded by a
+// changes event when the refetch changed it. Takes the same gym and facility
+// filters as /schedule.
+func scheduleStream(c *gin.Context) {
+	date := c.Query("date")
+	parsedDate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date parameter is required and must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	filter, err := parseScheduleFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// subscribe before reading the current schedule so no refetch is missed
+	updates := scheduleHub.Subscribe(date)
+	defer scheduleHub.Unsubscribe(date, updates)
+
+	result, err := getOrFetchSchedule(c.Request.Context(), parsedDate)
+	if err != nil {
+		log.Printf("Error on fetch of %s for a stream: %v\n", date, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	// keep nginx and friends from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+
+	current := filter.Apply(result.Schedule)
+	if result.Stale {
+		current = markStale(current)
+	}
+	c.SSEvent("schedule", current)
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepalive.C:
+			c.SSEvent("ping", gin.H{"at": time.Now()})
+			return true
+		case update, open := <-updates:
+			if !open {
+				return false
+			}
+
+			if update.Change != nil {
+				if diff := filter.ApplyDiff(update.Change.Diff); !diff.Empty() {
+					c.SSEvent("changes", models.ChangeResp{
+						DetectedAt:        update.Change.DetectedAt,
+						PreviousFetchedAt: update.Change.PreviousFetchedAt,
+						ScheduleDiff:      diff,
+					})
+				}
+			}
+
+			c.SSEvent("schedule", filter.Apply(update.Schedule))
+			return true
+		}
+	})
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// a delivery is attempted up to WEBHOOK_MAX_ATTEMPTS times, waiting
+// WEBHOOK_INITIAL_BACKOFF after the first failure and doubling up to
+// WEBHOOK_MAX_BACKOFF after each one, before it is dead lettered
+const (
+	WEBHOOK_MAX_ATTEMPTS    = 6
+	WEBHOOK_INITIAL_BACKOFF = 2 * time.Second
+	WEBHOOK_MAX_BACKOFF     = 5 * time.Minute
+)
+
+// how long a receiver gets to respond to a single attempt
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// how many webhooks can be registered at once
+const MAX_WEBHOOKS = 100
+
+// how many deliveries can wait to be picked up, and how many can be POSTing at once
+const (
+	WEBHOOK_QUEUE_SIZE  = 256
+	WEBHOOK_CONCURRENCY = 8
+)
+
+// webhookDelivery is one change POSTed to one webhook, Attempts times so far
+type webhookDelivery struct {
+	Webhook    models.Webhook
+	DeliveryID string
+	Body       []byte
+	Attempts   int
+	LastError  error
+}
+
+// WebhookDispatcher POSTs schedule changes to the webhooks they concern.
+// Deliveries are signed with the webhook's secret, retried with exponential
+// backoff, and written to webhook_dead_letters once they run out of attempts.
+// A fixed set of workers POSTs deliveries from the queue, and a delivery
+// waiting to be retried is held by a timer that queues it again when due,
+// so the queue bounds the work in flight.
+type WebhookDispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+	queue  chan webhookDelivery
+
+	// the deliveries waiting to be retried by delivery id, and whether Run
+	// has stopped, in which case nothing is retried anymore
+	mu      sync.Mutex
+	retries map[string]pendingRetry
+	stopped bool
+
+	// writes a dead letter, to the webhook_dead_letters table unless a test
+	// replaces it
+	saveDeadLetter func(letter models.WebhookDeadLetter) error
+
+	// the first retry waits this long, see WEBHOOK_INITIAL_BACKOFF
+	InitialBackoff time.Duration
+	MaxAttempts    int
+}
+
+type pendingRetry struct {
+	timer    *time.Timer
+	delivery webhookDelivery
+}
+
+// the dispatcher schedule changes are sent to, set up in main
+var webhooks *WebhookDispatcher
+
+// NewWebhookDispatcher reads webhooks from and writes dead letters to db and
+// POSTs with client
+func NewWebhookDispatcher(db *gorm.DB, client *http.Client) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:             db,
+		client:         client,
+		queue:          make(chan webhookDelivery, WEBHOOK_QUEUE_SIZE),
+		retries:        make(map[string]pendingRetry),
+		InitialBackoff: WEBHOOK_INITIAL_BACKOFF,
+		MaxAttempts:    WEBHOOK_MAX_ATTEMPTS,
+		saveDeadLetter: func(letter models.WebhookDeadLetter) error {
+			return db.Create(&letter).Error
+		},
+	}
+}
+
+// Notify queues a delivery of change to every webhook whose gyms, facilities,
+// and dates it concerns. It never blocks, a delivery that doesn't fit in the
+// queue, or that comes in after Run has stopped, is dead lettered.
+func (d *WebhookDispatcher) Notify(change models.ScheduleChange) {
+	var hooks []models.Webhook
+	err := d.db.Where("(start_date IS NULL OR start_date <= ?) AND (end_date IS NULL OR end_date >= ?)",
+		change.ScheduleDate, change.ScheduleDate).Find(&hooks).Error
+	if err != nil {
+		log.Printf("Error finding webhooks for the %s change: %v\n", change.ScheduleDate.Format("2006-01-02"), err)
+		return
+	}
+
+	for _, hook := range hooks {
+		diff := webhookFilter(hook).ApplyDiff(change.Diff)
+		if diff.Empty() {
+			continue
+		}
+
+		payload := models.WebhookPayload{
+			WebhookID:         hook.WebhookID,
+			DeliveryID:        uuid.New().String(),
+			Date:              change.ScheduleDate.Format("2006-01-02"),
+			DetectedAt:        change.DetectedAt,
+			PreviousFetchedAt: change.PreviousFetchedAt,
+			ScheduleDiff:      diff,
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Error encoding webhook %s payload: %v\n", hook.WebhookID, err)
+			continue
+		}
+
+		d.enqueue(webhookDelivery{Webhook: hook, DeliveryID: payload.DeliveryID, Body: body})
+	}
+}
+
+// queues delivery for its first attempt. Checking stopped under mu means a
+// delivery is either queued before Run drains the queue or dead lettered, a
+// shared fetch can notify after Run has returned.
+func (d *WebhookDispatcher) enqueue(delivery webhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		d.deadLetter(delivery, 0, errors.New("shut down before delivery"))
+		return
+	}
+
+	select {
+	case d.queue <- delivery:
+	default:
+		d.deadLetter(delivery, 0, errors.New("webhook queue is full"))
+	}
+}
+
+// Run delivers queued webhooks with WEBHOOK_CONCURRENCY workers until ctx is
+// cancelled. Deliveries still queued or waiting to be retried when it is
+// cancelled are dead lettered, and Run returns once every worker has stopped.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range WEBHOOK_CONCURRENCY {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-d.queue:
+					d.attempt(ctx, delivery)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// nothing will pick these up anymore. A retry whose timer already fired
+	// dead letters itself once it sees stopped.
+	d.mu.Lock()
+	d.stopped = true
+	for id, retry := range d.retries {
+		if retry.timer.Stop() {
+			delete(d.retries, id)
+			d.deadLetter(retry.delivery, retry.delivery.Attempts, fmt.Errorf("shut down before retrying: %w", retry.delivery.LastError))
+		}
+	}
+	d.mu.Unlock()
+
+	for {
+		select {
+		case delivery := <-d.queue:
+			d.deadLetter(delivery, delivery.Attempts, errors.New("shut down before delivery"))
+		default:
+			log.Println("Webhook dispatcher stopped")
+			return
+		}
+	}
+}
+
+// makes one attempt at delivery, then schedules a retry or dead letters it
+// if it failed
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery webhookDelivery) {
+	err := d.deliver(ctx, delivery)
+	if err == nil {
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err
+	log.Printf("Webhook %s delivery %s attempt %d failed: %v\n", delivery.Webhook.WebhookID, delivery.DeliveryID, delivery.Attempts, err)
+
+	var permanent permanentDeliveryError
+	switch {
+	case ctx.Err() != nil:
+		d.deadLetter(delivery, delivery.Attempts, fmt.Errorf("shut down during delivery: %w", err))
+	case errors.As(err, &permanent), delivery.Attempts >= d.MaxAttempts:
+		d.deadLetter(delivery, delivery.Attempts, err)
+	default:
+		d.retryLater(delivery)
+	}
+}
+
+// queues delivery again once its backoff has passed. It is dead lettered
+// instead if the queue is full or Run has stopped by then.
+func (d *WebhookDispatcher) retryLater(delivery webhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		d.deadLetter(delivery, delivery.Attempts, fmt.Errorf("shut down before retrying: %w", delivery.LastError))
+		return
+	}
+
+	timer := time.AfterFunc(d.backoff(delivery.Attempts), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		delete(d.retries, delivery.DeliveryID)
+		if d.stopped {
+			d.deadLetter(delivery, delivery.Attempts, fmt.Errorf("shut down before retrying: %w", delivery.LastError))
+			return
+		}
+
+		select {
+		case d.queue <- delivery:
+		default:
+			d.deadLetter(delivery, delivery.Attempts, fmt.Errorf("webhook queue is full, last error: %w", delivery.LastError))
+		}
+	})
+	d.retries[delivery.DeliveryID] = pendingRetry{timer: timer, delivery: delivery}
+}
+
+// the receiver rejected the delivery in a way retrying won't fix
+type permanentDeliveryError struct {
+	err error
+}
+
+func (e permanentDeliveryError) Error() string {
+	return e.err.Error()
+}
+
+// makes a single attempt at delivery, any 2xx response is a success
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery webhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return permanentDeliveryError{err}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", delivery.Webhook.WebhookID)
+	req.Header.Set("X-Webhook-Delivery", delivery.DeliveryID)
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signWebhook(delivery.Webhook.Secret, timestamp, delivery.Body))
+
+	resp, err := d.client.Do(req)
+	if errors.Is(err, errNotPublicAddress) {
+		return permanentDeliveryError{err}
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return permanentDeliveryError{fmt.Errorf("receiver responded %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("receiver responded %d", resp.StatusCode)
+	}
+}
+
+// how long to wait after the attempts-th failed attempt
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	backoff := time.Duration(float64(d.InitialBackoff) * math.Pow(2, float64(attempts-1)))
+	if backoff > WEBHOOK_MAX_BACKOFF {
+		backoff = WEBHOOK_MAX_BACKOFF
+	}
+	return jitter(backoff)
+}
+
+func (d *WebhookDispatcher) deadLetter(delivery webhookDelivery, attempts int, cause error) {
+	letter := models.WebhookDeadLetter{
+		DeliveryID: delivery.DeliveryID,
+		WebhookID:  delivery.Webhook.WebhookID,
+		Payload:    string(delivery.Body),
+		Attempts:   attempts,
+		LastError:  cause.Error(),
+		Created:    time.Now(),
+	}
+
+	if err := d.saveDeadLetter(letter); err != nil {
+		log.Printf("Error dead lettering webhook %s delivery %s: %v\n", delivery.Webhook.WebhookID, delivery.DeliveryID, err)
+	}
+}
+
+// webhooks are only delivered to public addresses, so registering one can't
+// be used to reach the backend's own network
+var errNotPublicAddress = errors.New("webhook address is not public")
+
+// 100.64.0.0/10, carrier-grade NAT, which net.IP doesn't count as private
+var sharedAddressSpace = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// reports whether ip is a public unicast address, not loopback, private,
+// link local (which includes cloud metadata services), or unspecified
+func isPublicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// newWebhookClient returns the client deliveries are POSTed with. It checks
+// every address it connects to is public when it dials, so neither a host
+// that resolves to an internal address after registering nor a redirect can
+// reach one. It never uses a proxy.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: WEBHOOK_TIMEOUT,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicAddress(ip) {
+				return fmt.Errorf("%w: %s", errNotPublicAddress, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: WEBHOOK_TIMEOUT,
+		},
+	}
+}
+
+// checks every address host resolves to is public, see newWebhookClient
+func checkWebhookHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicAddress(ip) {
+			return errNotPublicAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("could not resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicAddress(addr.IP) {
+			return errNotPublicAddress
+		}
+	}
+	return nil
+}
+
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret, receivers
+// recompute it to check a delivery came from us and wasn't replayed
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookFilter(hook models.Webhook) ScheduleFilter {
+	var filter ScheduleFilter
+	if hook.Gyms != "" {
+		filter.Gyms = strings.Split(hook.Gyms, ",")
+	}
+	if hook.Facilities != "" {
+		filter.Facilities = strings.Split(hook.Facilities, ",")
+	}
+	return filter
+}
+
+// Handlers
+
+type webhookRequest struct {
+	URL        string   `json:"url"`
+	Gyms       []string `json:"gym"`
+	Facilities []string `json:"facility"`
+	Start      string   `json:"start"`
+	End        string   `json:"end"`
+}
+
+// POST /webhooks registers a webhook and returns it along with the secret its
+// deliveries are signed with, which is also needed to look it up or delete it.
+// Authorized with WEBHOOK_TOKEN as a bearer token, see requireToken.
+func registerWebhook(c *gin.Context) {
+	var body webhookRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+		return
+	}
+
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http or https URL"})
+		return
+	}
+
+	if err := checkWebhookHost(c.Request.Context(), target.Hostname()); errors.Is(err, errNotPublicAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must not point to a loopback, private, or link local address"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	gyms, err := parseList("gym", body.Gyms, buildings.Slugs())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	facilities, err := parseList("facility", body.Facilities, classifier.Load().Facilities())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, err := parseOptionalDate(body.Start)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	end, err := parseOptionalDate(body.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be of the form yyyy-MM-dd"})
+		return
+	}
+
+	if start != nil && end != nil && end.Before(*start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must not be before start"})
+		return
+	}
+
+	var registered int64
+	if err := DB.Model(&models.Webhook{}).Count(&registered).Error; err != nil {
+		log.Printf("Error counting webhooks: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+	if registered >= MAX_WEBHOOKS {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("at most %d webhooks can be registered", MAX_WEBHOOKS)})
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating webhook secret: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	hook := models.Webhook{
+		WebhookID:  uuid.New().String(),
+		URL:        target.String(),
+		Secret:     hex.EncodeToString(secret),
+		Gyms:       strings.Join(gyms, ","),
+		Facilities: strings.Join(facilities, ","),
+		StartDate:  start,
+		EndDate:    end,
+		Created:    time.Now(),
+	}
+
+	if err := DB.Create(&hook).Error; err != nil {
+		log.Printf("Error registering webhook: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	resp := webhookResp(hook)
+	resp.Secret = hook.Secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GET /webhooks/:id returns a webhook, authorized with its secret as a bearer token
+func getWebhook(c *gin.Context) {
+	hook, ok := authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResp(hook))
+}
+
+// DELETE /webhooks/:id deletes a webhook and its dead letters, authorized
+// with its secret as a bearer token
+func deleteWebhook(c *gin.Context) {
+	hook, ok := authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.WebhookID).Delete(&models.WebhookDeadLetter{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&hook).Error
+	})
+	if err != nil {
+		log.Printf("Error deleting webhook %s: %v\n", hook.WebhookID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GET /webhooks/:id/dead-letters lists the deliveries to a webhook that
+// failed every attempt, newest first
+func listWebhookDeadLetters(c *gin.Context) {
+	hook, ok := authorizeWebhook(c)
+	if !ok {
+		return
+	}
+
+	var letters []models.WebhookDeadLetter
+	if err := DB.Where("webhook_id = ?", hook.WebhookID).Order("created DESC").Find(&letters).Error; err != nil {
+		log.Printf("Error listing webhook %s dead letters: %v\n", hook.WebhookID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return
+	}
+
+	resp := make([]gin.H, 0, len(letters))
+	for _, letter := range letters {
+		resp = append(resp, gin.H{
+			"delivery_id": letter.DeliveryID,
+			"payload":     json.RawMessage(letter.Payload),
+			"attempts":    letter.Attempts,
+			"last_error":  letter.LastError,
+			"created":     letter.Created,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dead_letters": resp})
+}
+
+// looks up the webhook in the id path parameter and checks the request's
+// bearer token is its secret, responding with an error if not
+func authorizeWebhook(c *gin.Context) (models.Webhook, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return models.Webhook{}, false
+	}
+
+	var hook models.Webhook
+	err = DB.Where("webhook_id = ?", id.String()).First(&hook).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return models.Webhook{}, false
+	} else if err != nil {
+		log.Printf("Error getting webhook %s: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong on our end"})
+		return models.Webhook{}, false
+	}
+
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(hook.Secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the webhook's secret must be sent as a bearer token"})
+		return models.Webhook{}, false
+	}
+
+	return hook, true
+}
+
+func webhookResp(hook models.Webhook) models.WebhookResp {
+	resp := models.WebhookResp{
+		Id:         hook.WebhookID,
+		URL:        hook.URL,
+		Gyms:       webhookFilter(hook).Gyms,
+		Facilities: webhookFilter(hook).Facilities,
+		Created:    hook.Created,
+	}
+	if resp.Gyms == nil {
+		resp.Gyms = []string{}
+	}
+	if resp.Facilities == nil {
+		resp.Facilities = []string{}
+	}
+
+	if hook.StartDate != nil {
+		start := hook.StartDate.Format("2006-01-02")
+		resp.Start = &start
+	}
+	if hook.EndDate != nil {
+		end := hook.EndDate.Format("2006-01-02")
+		resp.End = &end
+	}
+
+	return resp
+}
+
+// parses a yyyy-mm-dd date, or nil if value is empty
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
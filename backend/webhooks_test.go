@@ -0,0 +1,216 @@
+package main
+
+import (
+	"UWOpenRecRoster2-Backend/models"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a dispatcher POSTing with client that sends dead letters to the returned
+// channel instead of the database
+func newTestDispatcher(client *http.Client) (*WebhookDispatcher, chan models.WebhookDeadLetter) {
+	deadLetters := make(chan models.WebhookDeadLetter, WEBHOOK_QUEUE_SIZE)
+	dispatcher := NewWebhookDispatcher(nil, client)
+	dispatcher.InitialBackoff = time.Millisecond
+	dispatcher.MaxAttempts = 3
+	dispatcher.saveDeadLetter = func(letter models.WebhookDeadLetter) error {
+		deadLetters <- letter
+		return nil
+	}
+	return dispatcher, deadLetters
+}
+
+func testDelivery(url string) webhookDelivery {
+	return webhookDelivery{
+		Webhook:    models.Webhook{WebhookID: "hook", URL: url, Secret: "secret"},
+		DeliveryID: "delivery",
+		Body:       []byte(`{"date":"2025-04-01"}`),
+	}
+}
+
+// runs dispatcher until the test ends
+func runDispatcher(t *testing.T, dispatcher *WebhookDispatcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	dispatcher, _ := newTestDispatcher(server.Client())
+	delivery := testDelivery(server.URL)
+	if err := dispatcher.deliver(context.Background(), delivery); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if string(body) != string(delivery.Body) {
+		t.Errorf("body = %s, want %s", body, delivery.Body)
+	}
+	if got := header.Get("X-Webhook-Id"); got != "hook" {
+		t.Errorf("X-Webhook-Id = %q, want hook", got)
+	}
+	if got := header.Get("X-Webhook-Delivery"); got != "delivery" {
+		t.Errorf("X-Webhook-Delivery = %q, want delivery", got)
+	}
+
+	// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	timestamp, signature, found := strings.Cut(header.Get("X-Webhook-Signature"), ",v1=")
+	timestamp, hasTimestamp := strings.CutPrefix(timestamp, "t=")
+	if !found || !hasTimestamp {
+		t.Fatalf("X-Webhook-Signature = %q, want t=...,v1=...", header.Get("X-Webhook-Signature"))
+	}
+	if want := signWebhook("secret", timestamp, body); signature != want {
+		t.Errorf("signature = %s, want %s", signature, want)
+	}
+	if signature == signWebhook("another secret", timestamp, body) {
+		t.Errorf("signature doesn't depend on the secret")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name           string
+		statuses       []int // the receiver's responses in order, the last one repeated
+		wantAttempts   int
+		wantDeadLetter bool
+	}{
+		{"delivered", []int{http.StatusOK}, 1, false},
+		{"delivered after retrying", []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusNoContent}, 3, false},
+		{"server error", []int{http.StatusInternalServerError}, 3, true},
+		{"request timeout", []int{http.StatusRequestTimeout}, 3, true},
+		{"too many requests", []int{http.StatusTooManyRequests}, 3, true},
+		{"bad request", []int{http.StatusBadRequest}, 1, true},
+		{"gone", []int{http.StatusGone}, 1, true},
+		{"bad request after a server error", []int{http.StatusInternalServerError, http.StatusBadRequest}, 2, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			delivered := make(chan struct{}, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := int(attempts.Add(1))
+				status := test.statuses[min(attempt, len(test.statuses))-1]
+				w.WriteHeader(status)
+				if status < 300 {
+					delivered <- struct{}{}
+				}
+			}))
+			defer server.Close()
+
+			dispatcher, deadLetters := newTestDispatcher(server.Client())
+			runDispatcher(t, dispatcher)
+			dispatcher.enqueue(testDelivery(server.URL))
+
+			select {
+			case letter := <-deadLetters:
+				if !test.wantDeadLetter {
+					t.Fatalf("dead lettered after %d attempts: %s", letter.Attempts, letter.LastError)
+				}
+				if letter.Attempts != test.wantAttempts || letter.DeliveryID != "delivery" || letter.WebhookID != "hook" {
+					t.Errorf("dead letter = %+v, want delivery of hook after %d attempts", letter, test.wantAttempts)
+				}
+			case <-delivered:
+				if test.wantDeadLetter {
+					t.Fatal("delivered, want a dead letter")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("neither delivered nor dead lettered")
+			}
+
+			if got := int(attempts.Load()); got != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherShutdown(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dispatcher, deadLetters := newTestDispatcher(server.Client())
+	// long enough that the retry is still pending at shutdown
+	dispatcher.InitialBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dispatcher.Run(ctx)
+	}()
+
+	dispatcher.enqueue(testDelivery(server.URL))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dispatcher.mu.Lock()
+		pending := len(dispatcher.retries)
+		dispatcher.mu.Unlock()
+		if pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the failed delivery was never scheduled for a retry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	select {
+	case letter := <-deadLetters:
+		if letter.Attempts != 1 || !strings.Contains(letter.LastError, "shut down before retrying") {
+			t.Errorf("dead letter = %+v, want the pending retry after 1 attempt", letter)
+		}
+	default:
+		t.Error("the pending retry wasn't dead lettered on shutdown")
+	}
+
+	// a change notified after Run returned, e.g. by a shared fetch
+	delivery := testDelivery(server.URL)
+	delivery.DeliveryID = "late"
+	dispatcher.enqueue(delivery)
+	select {
+	case letter := <-deadLetters:
+		if letter.DeliveryID != "late" || letter.Attempts != 0 {
+			t.Errorf("dead letter = %+v, want the late delivery with no attempts", letter)
+		}
+	default:
+		t.Error("a delivery queued after shutdown wasn't dead lettered")
+	}
+	if len(dispatcher.queue) != 0 {
+		t.Errorf("%d deliveries left in the queue after shutdown", len(dispatcher.queue))
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}